@@ -1,26 +1,42 @@
 package analytics
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
+	"fourinrow/events"
+
 	"github.com/IBM/sarama"
 )
 
-type GameEvent struct {
-	Type      string      `json:"type"`
-	GameID    string      `json:"game_id"`
-	PlayerID  string      `json:"player_id"`
-	Timestamp int64       `json:"timestamp"`
-	Payload   interface{} `json:"payload"`
-}
-
 type ProducerInterface interface {
-	Emit(event GameEvent)
+	// Emit is fire-and-forget: failures are logged and the event is dropped
+	Emit(event events.Event)
+	// Send reports whether the event was delivered, so callers can retry
+	Send(event events.Event) error
 	Close()
 }
 
+// batchSender is implemented by producers that can have many events in
+// flight at once, so a batch costs one round trip rather than one each.
+type batchSender interface {
+	SendAll(evs []events.Event) []error
+}
+
+// SendAll sends the events and returns one error per event, nil for those
+// delivered. It uses the producer's batch path when there is one.
+func SendAll(p ProducerInterface, evs []events.Event) []error {
+	if b, ok := p.(batchSender); ok {
+		return b.SendAll(evs)
+	}
+	errs := make([]error, len(evs))
+	for i, e := range evs {
+		errs[i] = p.Send(e)
+	}
+	return errs
+}
+
 // ---------------------------------------------------------
 // 1. KAFKA PRODUCER (The Real Deal)
 // ---------------------------------------------------------
@@ -45,30 +61,66 @@ func NewKafkaProducer(brokers []string, topic string) *KafkaProducer {
 	return &KafkaProducer{producer: p, topic: topic}
 }
 
-func (k *KafkaProducer) Emit(event GameEvent) {
-	// Ensure timestamp is set
-	if event.Timestamp == 0 {
-		event.Timestamp = time.Now().Unix()
+func (k *KafkaProducer) Emit(event events.Event) {
+	if err := k.Send(event); err != nil {
+		log.Printf("[ANALYTICS] Failed to send message: %v", err)
 	}
+}
 
-	// Convert event to JSON bytes
-	val, err := json.Marshal(event)
+func (k *KafkaProducer) Send(event events.Event) error {
+	msg, err := encode(k.topic, event)
 	if err != nil {
-		log.Printf("[ANALYTICS] JSON Error: %v", err)
-		return
+		return err
 	}
+	_, _, err = k.producer.SendMessage(msg)
+	return err
+}
 
-	// Send message
-	msg := &sarama.ProducerMessage{
-		Topic: k.topic,
-		Key:   sarama.StringEncoder(event.GameID), // Ensure events for same game go to same partition
-		Value: sarama.ByteEncoder(val),
+// SendAll sends the events in one request and matches the failures back.
+func (k *KafkaProducer) SendAll(evs []events.Event) []error {
+	errs := make([]error, len(evs))
+	msgs := make([]*sarama.ProducerMessage, 0, len(evs))
+	index := make(map[*sarama.ProducerMessage]int, len(evs))
+	for i, event := range evs {
+		msg, err := encode(k.topic, event)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		index[msg] = i
+		msgs = append(msgs, msg)
 	}
+	err := k.producer.SendMessages(msgs)
+	var perrs sarama.ProducerErrors
+	if errors.As(err, &perrs) {
+		for _, perr := range perrs {
+			errs[index[perr.Msg]] = perr.Err
+		}
+	} else if err != nil {
+		for _, msg := range msgs {
+			errs[index[msg]] = err
+		}
+	}
+	return errs
+}
 
-	_, _, err = k.producer.SendMessage(msg)
+func encode(topic string, event events.Event) (*sarama.ProducerMessage, error) {
+	// Ensure timestamp is set
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+
+	// Convert event to JSON bytes, checked against the schema
+	val, err := events.Marshal(event)
 	if err != nil {
-		log.Printf("[ANALYTICS] Failed to send message: %v", err)
+		return nil, err
 	}
+
+	return &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(event.Key()), // Ensure events for same game go to same partition
+		Value: sarama.ByteEncoder(val),
+	}, nil
 }
 
 func (k *KafkaProducer) Close() {
@@ -81,9 +133,13 @@ func (k *KafkaProducer) Close() {
 type StubProducer struct{}
 
 func NewStubProducer() *StubProducer { return &StubProducer{} }
-func (s *StubProducer) Emit(event GameEvent) {
+func (s *StubProducer) Emit(event events.Event) {
 	log.Printf("[ANALYTICS STUB] %+v\n", event)
 }
+func (s *StubProducer) Send(event events.Event) error {
+	s.Emit(event)
+	return nil
+}
 func (s *StubProducer) Close() {}
 
 // Global Instance
@@ -0,0 +1,381 @@
+package analytics
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fourinrow/events"
+
+	"github.com/IBM/sarama"
+)
+
+// OverflowPolicy decides what Emit does when the buffer is full.
+type OverflowPolicy string
+
+const (
+	OverflowDropOldest OverflowPolicy = "drop_oldest" // discard the oldest buffered event
+	OverflowBlock      OverflowPolicy = "block"       // wait for room (stalls the caller)
+	OverflowSpill      OverflowPolicy = "spill"       // append to a file and send it later
+)
+
+var (
+	ErrDropped = errors.New("analytics buffer full, event dropped")
+	ErrClosed  = errors.New("analytics producer closed")
+)
+
+// AsyncConfig tunes AsyncProducer. Zero values take the defaults.
+type AsyncConfig struct {
+	Buffer       int           // events held in memory before the overflow policy applies (10000)
+	BatchSize    int           // messages per Kafka request (100)
+	Linger       time.Duration // longest a partial batch waits (100ms)
+	Compression  sarama.CompressionCodec
+	Overflow     OverflowPolicy // default OverflowDropOldest
+	SpillPath    string         // JSONL file for OverflowSpill (analytics-spill.jsonl)
+	CloseTimeout time.Duration  // how long Close waits to flush (5s)
+
+	// OnError is called for every event that could not be delivered, from a
+	// background goroutine. The default logs it.
+	OnError func(event events.Event, err error)
+}
+
+func (c *AsyncConfig) setDefaults() {
+	if c.Buffer <= 0 {
+		c.Buffer = 10000
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.Linger <= 0 {
+		c.Linger = 100 * time.Millisecond
+	}
+	if c.Overflow == "" {
+		c.Overflow = OverflowDropOldest
+	}
+	if c.SpillPath == "" {
+		c.SpillPath = "analytics-spill.jsonl"
+	}
+	if c.CloseTimeout <= 0 {
+		c.CloseTimeout = 5 * time.Second
+	}
+	if c.OnError == nil {
+		c.OnError = func(event events.Event, err error) {
+			log.Printf("[ANALYTICS] Failed to deliver %s event for game %s: %v", event.Type, event.GameID, err)
+		}
+	}
+}
+
+// AsyncProducer sends events to Kafka in the background so Emit never waits
+// on the broker. Events queue in a bounded buffer and sarama batches and
+// compresses them.
+type AsyncProducer struct {
+	cfg      AsyncConfig
+	producer sarama.AsyncProducer
+	topic    string
+	buf      chan events.Event
+	spill    *spillFile // nil unless the policy is OverflowSpill
+
+	mu     sync.RWMutex // held for writing only to close buf
+	closed atomic.Bool  // set by Close before it waits for mu
+
+	stop       chan struct{} // closed when Close's deadline passes
+	pumpDone   chan struct{}
+	resultDone chan struct{}
+	inFlight   atomic.Int64
+}
+
+// delivery rides along with a message so results can be matched to events.
+type delivery struct {
+	event events.Event
+	done  chan error // set by Send
+}
+
+func NewAsyncProducer(brokers []string, topic string, cfg AsyncConfig) *AsyncProducer {
+	cfg.setDefaults()
+
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Compression = cfg.Compression
+	config.Producer.Flush.Messages = cfg.BatchSize
+	config.Producer.Flush.Frequency = cfg.Linger
+
+	p, err := sarama.NewAsyncProducer(brokers, config)
+	if err != nil {
+		log.Printf("[ANALYTICS] ⚠️ Failed to start Kafka producer: %v (Using Stub instead)", err)
+		return nil
+	}
+
+	log.Printf("[ANALYTICS] ✅ Connected to Kafka! (async, buffer %d, batch %d, %s compression, on overflow: %s)",
+		cfg.Buffer, cfg.BatchSize, cfg.Compression, cfg.Overflow)
+	return newAsyncProducer(p, topic, cfg)
+}
+
+func newAsyncProducer(p sarama.AsyncProducer, topic string, cfg AsyncConfig) *AsyncProducer {
+	a := &AsyncProducer{
+		cfg:        cfg,
+		producer:   p,
+		topic:      topic,
+		buf:        make(chan events.Event, cfg.Buffer),
+		stop:       make(chan struct{}),
+		pumpDone:   make(chan struct{}),
+		resultDone: make(chan struct{}),
+	}
+	if cfg.Overflow == OverflowSpill {
+		a.spill = newSpillFile(cfg.SpillPath)
+	}
+	go a.pump()
+	go a.results()
+	return a
+}
+
+// Emit queues the event and returns at once, unless the policy is
+// OverflowBlock and the buffer is full.
+func (a *AsyncProducer) Emit(event events.Event) {
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	// Fix the ID now, as a failed event may be encoded again
+	if event.ID == "" {
+		event.ID = events.NewID()
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed.Load() {
+		a.cfg.OnError(event, ErrClosed)
+		return
+	}
+
+	switch a.cfg.Overflow {
+	case OverflowBlock:
+		select {
+		case a.buf <- event:
+		case <-a.stop:
+			a.cfg.OnError(event, ErrClosed)
+		}
+
+	case OverflowSpill:
+		// Once spilling, keep spilling until the file is drained so events
+		// stay roughly in order
+		if !a.spill.pending() {
+			select {
+			case a.buf <- event:
+				return
+			default:
+			}
+		}
+		if err := a.spill.write(event); err != nil {
+			a.cfg.OnError(event, err)
+		}
+
+	default: // OverflowDropOldest
+		for {
+			select {
+			case a.buf <- event:
+				return
+			default:
+			}
+			select {
+			case old := <-a.buf:
+				a.cfg.OnError(old, ErrDropped)
+			default:
+			}
+		}
+	}
+}
+
+// Send bypasses the buffer and waits until Kafka acknowledges the event.
+func (a *AsyncProducer) Send(event events.Event) error {
+	return a.SendAll([]events.Event{event})[0]
+}
+
+// SendAll bypasses the buffer, hands every event to sarama so they share
+// batches, and waits until Kafka has acknowledged or failed each one.
+func (a *AsyncProducer) SendAll(evs []events.Event) []error {
+	errs := make([]error, len(evs))
+	done := make([]chan error, len(evs))
+	a.mu.RLock()
+	for i, event := range evs {
+		if a.closed.Load() {
+			errs[i] = ErrClosed
+			continue
+		}
+		msg, err := encode(a.topic, event)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		d := &delivery{event: event, done: make(chan error, 1)}
+		msg.Metadata = d
+		if !a.input(msg) {
+			errs[i] = ErrClosed
+			continue
+		}
+		done[i] = d.done
+	}
+	a.mu.RUnlock()
+
+	for i, ch := range done {
+		if ch != nil {
+			errs[i] = <-ch
+		}
+	}
+	return errs
+}
+
+// input hands a message to sarama, giving up if Close runs out of time.
+func (a *AsyncProducer) input(msg *sarama.ProducerMessage) bool {
+	a.inFlight.Add(1)
+	select {
+	case a.producer.Input() <- msg:
+		return true
+	case <-a.stop:
+		a.inFlight.Add(-1)
+		return false
+	}
+}
+
+// pump moves buffered events to sarama and feeds spilled events back once
+// the buffer has emptied.
+func (a *AsyncProducer) pump() {
+	defer close(a.pumpDone)
+	var retry <-chan time.Time
+	if a.spill != nil {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		retry = t.C
+	}
+
+	for {
+		select {
+		case event, ok := <-a.buf:
+			if !ok {
+				return
+			}
+			a.send(event)
+		case <-retry:
+			if len(a.buf) == 0 {
+				a.drainSpill()
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+func (a *AsyncProducer) send(event events.Event) bool {
+	msg, err := encode(a.topic, event)
+	if err != nil {
+		a.cfg.OnError(event, err)
+		return true
+	}
+	msg.Metadata = &delivery{event: event}
+	if !a.input(msg) {
+		if a.spill == nil || a.spill.write(event) != nil {
+			a.cfg.OnError(event, ErrClosed)
+		}
+		return false
+	}
+	return true
+}
+
+func (a *AsyncProducer) drainSpill() {
+	spilled, err := a.spill.take()
+	if err != nil {
+		log.Printf("[ANALYTICS] Failed to read spill file: %v", err)
+		return
+	}
+	for i, event := range spilled {
+		if !a.send(event) {
+			// Closing: put the rest back for the next start
+			a.spill.writeAll(spilled[i+1:])
+			return
+		}
+	}
+	if len(spilled) > 0 {
+		log.Printf("[ANALYTICS] Resent %d spilled events", len(spilled))
+	}
+}
+
+// results reports acknowledgements and failures until sarama shuts down.
+func (a *AsyncProducer) results() {
+	defer close(a.resultDone)
+	successes, errs := a.producer.Successes(), a.producer.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			a.inFlight.Add(-1)
+			if d, _ := msg.Metadata.(*delivery); d != nil && d.done != nil {
+				d.done <- nil
+			}
+		case perr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			a.inFlight.Add(-1)
+			d, _ := perr.Msg.Metadata.(*delivery)
+			switch {
+			case d == nil:
+				log.Printf("[ANALYTICS] Failed to send message: %v", perr.Err)
+			case d.done != nil:
+				d.done <- perr.Err
+			default:
+				a.cfg.OnError(d.event, perr.Err)
+			}
+		}
+	}
+}
+
+// Close stops accepting events and flushes what is buffered, waiting at most
+// CloseTimeout. Whatever is left after that is spilled to disk under
+// OverflowSpill and reported to OnError otherwise.
+func (a *AsyncProducer) Close() {
+	if !a.closed.CompareAndSwap(false, true) {
+		return
+	}
+
+	// Start the clock before taking mu: senders blocked on a full buffer or
+	// an unreachable broker hold it until stop releases them.
+	deadline := time.Now().Add(a.cfg.CloseTimeout)
+	timer := time.AfterFunc(a.cfg.CloseTimeout, func() { close(a.stop) })
+	defer timer.Stop()
+
+	a.mu.Lock()
+	close(a.buf)
+	a.mu.Unlock()
+
+	<-a.pumpDone
+	var left []events.Event
+	for event := range a.buf {
+		left = append(left, event)
+	}
+	if len(left) > 0 {
+		if a.spill != nil {
+			if err := a.spill.writeAll(left); err == nil {
+				log.Printf("[ANALYTICS] Spilled %d unsent events to %s", len(left), a.spill.path)
+				left = nil
+			}
+		}
+		for _, event := range left {
+			a.cfg.OnError(event, ErrClosed)
+		}
+	}
+
+	// AsyncClose flushes the current batches before the result channels close
+	a.producer.AsyncClose()
+	select {
+	case <-a.resultDone:
+		log.Println("[ANALYTICS] Producer flushed and closed")
+	case <-time.After(time.Until(deadline)):
+		log.Printf("[ANALYTICS] ⚠️ Gave up waiting for %d in-flight events after %s", a.inFlight.Load(), a.cfg.CloseTimeout)
+	}
+}
@@ -0,0 +1,110 @@
+package analytics
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fourinrow/events"
+)
+
+// ChannelProducer hands events to in-process subscribers, so tests and local
+// tools can watch the event stream without Kafka.
+//
+//	ch := analytics.NewChannelProducer()
+//	analytics.Producer = ch
+//	sub := ch.Subscribe(100)
+//	... play a game ...
+//	e := <-sub // game_started
+type ChannelProducer struct {
+	mu      sync.Mutex
+	subs    []chan events.Event
+	closed  bool
+	dropped atomic.Int64
+}
+
+func NewChannelProducer() *ChannelProducer {
+	return &ChannelProducer{}
+}
+
+// Subscribe returns a channel that receives every event emitted from now on.
+// A subscriber whose buffer is full misses events (see Dropped) rather than
+// stalling the game. The channel is closed by Close.
+func (p *ChannelProducer) Subscribe(buffer int) <-chan events.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := make(chan events.Event, buffer)
+	if p.closed {
+		close(ch)
+		return ch
+	}
+	p.subs = append(p.subs, ch)
+	return ch
+}
+
+// Unsubscribe stops deliveries to ch and closes it.
+func (p *ChannelProducer) Unsubscribe(ch <-chan events.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, s := range p.subs {
+		if s == ch {
+			close(s)
+			p.subs = append(p.subs[:i], p.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Dropped counts deliveries missed because a subscriber was full.
+func (p *ChannelProducer) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+func (p *ChannelProducer) Emit(event events.Event) {
+	if err := p.Send(event); err != nil {
+		log.Printf("[ANALYTICS] Dropping %s event: %v", event.Type, err)
+	}
+}
+
+// Send delivers the event if it passes the schema, so tests catch events
+// that Kafka consumers would reject.
+func (p *ChannelProducer) Send(event events.Event) error {
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	event.SchemaVersion = events.SchemaVersion
+	if event.ID == "" {
+		event.ID = events.NewID()
+	}
+	if err := event.Validate(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	for _, s := range p.subs {
+		select {
+		case s <- event:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+	return nil
+}
+
+func (p *ChannelProducer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for _, s := range p.subs {
+		close(s)
+	}
+	p.subs = nil
+}
@@ -0,0 +1,52 @@
+package analytics
+
+import (
+	"errors"
+
+	"fourinrow/events"
+)
+
+// FanOut sends every event to each of its sinks, in order.
+type FanOut struct {
+	sinks []ProducerInterface
+}
+
+func NewFanOut(sinks ...ProducerInterface) *FanOut {
+	return &FanOut{sinks: sinks}
+}
+
+func (f *FanOut) Emit(event events.Event) {
+	for _, s := range f.sinks {
+		s.Emit(event)
+	}
+}
+
+// Send tries every sink and reports all their failures. A retry resends to
+// the sinks that succeeded too, so they see the event more than once.
+func (f *FanOut) Send(event events.Event) error {
+	var errs []error
+	for _, s := range f.sinks {
+		if err := s.Send(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SendAll sends the batch to each sink in turn; an event fails if any sink
+// failed it.
+func (f *FanOut) SendAll(evs []events.Event) []error {
+	errs := make([]error, len(evs))
+	for _, s := range f.sinks {
+		for i, err := range SendAll(s, evs) {
+			errs[i] = errors.Join(errs[i], err)
+		}
+	}
+	return errs
+}
+
+func (f *FanOut) Close() {
+	for _, s := range f.sinks {
+		s.Close()
+	}
+}
@@ -0,0 +1,140 @@
+package analytics
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"fourinrow/events"
+)
+
+// FileConfig tunes FileProducer. Zero values take the defaults.
+type FileConfig struct {
+	Dir      string // directory for the files ("analytics-events")
+	Prefix   string // file name prefix ("events")
+	MaxBytes int64  // start a new file past this size (64 MiB)
+	MaxFiles int    // delete the oldest files beyond this many; 0 keeps all
+}
+
+// FileProducer writes events as JSON lines for offline analysis without
+// Kafka. It starts a new file when the current one is full or a new UTC day
+// begins; files are named PREFIX-YYYYMMDD-HHMMSS.ffffff.jsonl so they sort in
+// order.
+type FileProducer struct {
+	cfg  FileConfig
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	day  string
+}
+
+func NewFileProducer(cfg FileConfig) (*FileProducer, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = "analytics-events"
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "events"
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 64 << 20
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	log.Printf("[ANALYTICS] ✅ Writing events to %s", cfg.Dir)
+	return &FileProducer{cfg: cfg}, nil
+}
+
+func (p *FileProducer) Emit(event events.Event) {
+	if err := p.Send(event); err != nil {
+		log.Printf("[ANALYTICS] Failed to write %s event: %v", event.Type, err)
+	}
+}
+
+func (p *FileProducer) Send(event events.Event) error {
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	line, err := events.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.rotate(int64(len(line))); err != nil {
+		return err
+	}
+	n, err := p.f.Write(line)
+	p.size += int64(n)
+	return err
+}
+
+// rotate opens a new file if there is none, the next line would overflow
+// the current one, or the day has changed. The caller holds p.mu.
+func (p *FileProducer) rotate(next int64) error {
+	now := time.Now().UTC()
+	day := now.Format("20060102")
+	if p.f != nil && p.day == day && (p.size == 0 || p.size+next <= p.cfg.MaxBytes) {
+		return nil
+	}
+	if p.f != nil {
+		if err := p.f.Close(); err != nil {
+			log.Printf("[ANALYTICS] Failed to close %s: %v", p.f.Name(), err)
+		}
+		p.f = nil
+	}
+
+	name := p.fileName(now)
+	for {
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		now = now.Add(time.Microsecond)
+		name = p.fileName(now)
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	p.f, p.size, p.day = f, 0, day
+	p.prune()
+	return nil
+}
+
+func (p *FileProducer) fileName(t time.Time) string {
+	return filepath.Join(p.cfg.Dir, fmt.Sprintf("%s-%s.jsonl", p.cfg.Prefix, t.Format("20060102-150405.000000")))
+}
+
+// prune deletes the oldest files beyond MaxFiles.
+func (p *FileProducer) prune() {
+	if p.cfg.MaxFiles <= 0 {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(p.cfg.Dir, p.cfg.Prefix+"-*.jsonl"))
+	if err != nil {
+		return
+	}
+	sort.Strings(files)
+	for len(files) > p.cfg.MaxFiles {
+		if files[0] != p.f.Name() {
+			os.Remove(files[0])
+		}
+		files = files[1:]
+	}
+}
+
+func (p *FileProducer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.f != nil {
+		p.f.Close()
+		p.f = nil
+	}
+}
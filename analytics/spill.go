@@ -0,0 +1,103 @@
+package analytics
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"sync"
+
+	"fourinrow/events"
+)
+
+// spillFile holds events that did not fit in AsyncProducer's buffer, one JSON
+// object per line. It survives restarts: a new producer resends what an old
+// one left behind.
+type spillFile struct {
+	mu   sync.Mutex
+	path string
+	n    int // events in the file; -1 for an unknown number left by an earlier run
+}
+
+func newSpillFile(path string) *spillFile {
+	s := &spillFile{path: path}
+	if _, err := os.Stat(path); err == nil {
+		s.n = -1
+	}
+	return s
+}
+
+func (s *spillFile) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n != 0
+}
+
+func (s *spillFile) write(event events.Event) error {
+	return s.writeAll([]events.Event{event})
+}
+
+func (s *spillFile) writeAll(batch []events.Event) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, event := range batch {
+		line, err := events.Marshal(event)
+		if err != nil {
+			continue // fails the schema, so it could never be sent
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if s.n < 0 {
+		s.n = 0
+	}
+	s.n += len(batch)
+	return f.Close()
+}
+
+// take reads and removes every spilled event.
+func (s *spillFile) take() ([]events.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.n = 0
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var spilled []events.Event
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if event, err := events.Decode(sc.Bytes()); err == nil {
+			spilled = append(spilled, event)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(s.path); err != nil {
+		return nil, err
+	}
+	s.n = 0
+	return spilled, nil
+}
@@ -6,13 +6,18 @@ import { Table, TableBody, TableCell, TableHead, TableHeader, TableRow } from "@
 import { ArrowLeft, Trophy } from "lucide-react";
 
 type LeaderboardEntry = {
+  rank: number;
   username: string;
   total_wins: number;
 };
 
+type LeaderboardResponse = {
+  entries: LeaderboardEntry[];
+};
+
 export default function Leaderboard() {
   const [, setLocation] = useLocation();
-  const { data, isLoading } = useQuery<LeaderboardEntry[]>({
+  const { data, isLoading } = useQuery<LeaderboardResponse>({
     queryKey: ["/leaderboard"],
   });
 
@@ -50,9 +55,9 @@ export default function Leaderboard() {
                       Loading stats...
                     </TableCell>
                   </TableRow>
-                ) : data?.map((entry, i) => (
+                ) : data?.entries.map((entry) => (
                   <TableRow key={entry.username} className="border-slate-800 text-slate-200 hover:bg-slate-800/50">
-                    <TableCell className="font-medium text-slate-500">#{i + 1}</TableCell>
+                    <TableCell className="font-medium text-slate-500">#{entry.rank}</TableCell>
                     <TableCell className="font-bold">{entry.username}</TableCell>
                     <TableCell className="text-right text-indigo-400">{entry.total_wins}</TableCell>
                   </TableRow>
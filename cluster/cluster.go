@@ -0,0 +1,89 @@
+// Package cluster holds the state that every server node has to agree on: who
+// is waiting for a match, which node owns each game in progress, and a pub/sub
+// channel per node for relaying messages between them.
+//
+// A game's board lives on the node that created it. A player connected to a
+// different node is relayed over pub/sub, so two players on different nodes
+// can still play each other.
+package cluster
+
+import (
+	"context"
+	"time"
+)
+
+// Route says which node owns a game in progress.
+type Route struct {
+	GameID string `json:"gameId"`
+	Owner  string `json:"owner"` // node ID
+}
+
+// Ticket is a player waiting for an opponent.
+type Ticket struct {
+	Username string    `json:"username"`
+	Node     string    `json:"node"` // where the player is connected
+	Since    time.Time `json:"since"`
+}
+
+// TicketTTL is how long a ticket stays matchable. Nodes take their players out
+// of the queue long before this, so an older ticket was left by a node that
+// crashed and is dropped instead of being matched with a ghost.
+const TicketTTL = 30 * time.Second
+
+func (t Ticket) expired(now time.Time) bool {
+	return now.Sub(t.Since) > TicketTTL
+}
+
+// Games is the shared index of games in progress.
+type Games interface {
+	PutGame(ctx context.Context, r Route, players []string) error
+	// Owner returns "" for an unknown game.
+	Owner(ctx context.Context, gameID string) (string, error)
+	// FindByPlayer returns nil if the player has no game in progress.
+	FindByPlayer(ctx context.Context, username string) (*Route, error)
+	DeleteGame(ctx context.Context, gameID string, players []string) error
+}
+
+// Queue is the matchmaking queue shared by every node.
+type Queue interface {
+	// Match pairs t with the longest-waiting other player and removes them
+	// from the queue, discarding expired tickets on the way. If nobody else
+	// is waiting, t is queued and Match returns nil. A player is never
+	// queued twice.
+	Match(ctx context.Context, t Ticket) (*Ticket, error)
+	// Leave takes a player out of the queue and reports whether they were
+	// still in it, i.e. nobody matched them first.
+	Leave(ctx context.Context, username string) (bool, error)
+}
+
+// PubSub delivers messages to every subscriber of a channel. Delivery is
+// best effort: a node that is not subscribed misses the message.
+type PubSub interface {
+	Publish(ctx context.Context, channel string, msg []byte) error
+	// Subscribe delivers messages on the returned channel until ctx is
+	// cancelled, then closes it.
+	Subscribe(ctx context.Context, channel string) (<-chan []byte, error)
+}
+
+type Backend interface {
+	Games
+	Queue
+	PubSub
+	Close() error
+}
+
+// Open returns the in-process backend for an empty URL and the Redis backend
+// for a redis:// URL.
+func Open(url string) (Backend, error) {
+	if url == "" || url == "local" {
+		return NewLocal(), nil
+	}
+	return NewRedis(url)
+}
+
+// NodeChannel is the pub/sub channel a node listens on.
+func NodeChannel(node string) string {
+	return keyPrefix + "node:" + node
+}
+
+const keyPrefix = "fourinrow:"
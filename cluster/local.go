@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Local is the single-process backend. It is the default and behaves like a
+// cluster of one node.
+type Local struct {
+	mu      sync.Mutex
+	owners  map[string]string // game ID -> owner node
+	players map[string]Route  // username -> game in progress
+	queue   []Ticket
+	subs    map[string]map[chan []byte]struct{}
+}
+
+func NewLocal() *Local {
+	return &Local{
+		owners:  make(map[string]string),
+		players: make(map[string]Route),
+		subs:    make(map[string]map[chan []byte]struct{}),
+	}
+}
+
+func (l *Local) Close() error { return nil }
+
+func (l *Local) PutGame(ctx context.Context, r Route, players []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.owners[r.GameID] = r.Owner
+	for _, p := range players {
+		l.players[p] = r
+	}
+	return nil
+}
+
+func (l *Local) Owner(ctx context.Context, gameID string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.owners[gameID], nil
+}
+
+func (l *Local) FindByPlayer(ctx context.Context, username string) (*Route, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.players[username]; ok {
+		return &r, nil
+	}
+	return nil, nil
+}
+
+func (l *Local) DeleteGame(ctx context.Context, gameID string, players []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.owners, gameID)
+	for _, p := range players {
+		if l.players[p].GameID == gameID {
+			delete(l.players, p)
+		}
+	}
+	return nil
+}
+
+func (l *Local) Match(ctx context.Context, t Ticket) (*Ticket, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.remove(t.Username)
+	now := time.Now()
+	for len(l.queue) > 0 {
+		opp := l.queue[0]
+		l.queue = l.queue[1:]
+		if !opp.expired(now) {
+			return &opp, nil
+		}
+	}
+	l.queue = append(l.queue, t)
+	return nil, nil
+}
+
+func (l *Local) Leave(ctx context.Context, username string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.remove(username), nil
+}
+
+func (l *Local) remove(username string) bool {
+	for i, t := range l.queue {
+		if t.Username == username {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Local) Publish(ctx context.Context, channel string, msg []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ch := range l.subs[channel] {
+		// Same contract as Redis: a subscriber that can't keep up loses messages
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+func (l *Local) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	ch := make(chan []byte, 256)
+	l.mu.Lock()
+	if l.subs[channel] == nil {
+		l.subs[channel] = make(map[chan []byte]struct{})
+	}
+	l.subs[channel][ch] = struct{}{}
+	l.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		l.mu.Lock()
+		delete(l.subs[channel], ch)
+		l.mu.Unlock()
+		close(ch)
+	}()
+	return ch, nil
+}
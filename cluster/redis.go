@@ -0,0 +1,198 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keys used by the Redis backend:
+//
+//	fourinrow:queue            list of waiting usernames, oldest first
+//	fourinrow:tickets          hash username -> Ticket JSON
+//	fourinrow:ticket_expiry    hash username -> unix ms after which the ticket is dropped
+//	fourinrow:game:<id>        owner node of a game in progress
+//	fourinrow:player:<name>    Route JSON of the player's game in progress
+//	fourinrow:node:<id>        pub/sub channel of a node
+const (
+	queueKey     = keyPrefix + "queue"
+	ticketsKey   = keyPrefix + "tickets"
+	ticketExpKey = keyPrefix + "ticket_expiry"
+
+	// Routes outlive any real game so a crashed node's entries eventually go
+	routeTTL = 24 * time.Hour
+)
+
+func gameKey(id string) string     { return keyPrefix + "game:" + id }
+func playerKey(name string) string { return keyPrefix + "player:" + name }
+
+// Pop the oldest other waiting player whose ticket has not expired, or queue
+// this one. The self-removal comes first so a reconnecting player can't be
+// matched with themselves. ARGV[3] is the caller's clock and ARGV[4] the new
+// ticket's expiry, both in unix ms.
+var matchScript = redis.NewScript(`
+redis.call('LREM', KEYS[1], 0, ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+local now = tonumber(ARGV[3])
+while true do
+	local opp = redis.call('LPOP', KEYS[1])
+	if not opp then
+		break
+	end
+	local t = redis.call('HGET', KEYS[2], opp)
+	local exp = tonumber(redis.call('HGET', KEYS[3], opp))
+	redis.call('HDEL', KEYS[2], opp)
+	redis.call('HDEL', KEYS[3], opp)
+	if t and exp and exp > now then
+		return t
+	end
+end
+redis.call('RPUSH', KEYS[1], ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[4])
+return false
+`)
+
+var leaveScript = redis.NewScript(`
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return redis.call('LREM', KEYS[1], 0, ARGV[1])
+`)
+
+var queueKeys = []string{queueKey, ticketsKey, ticketExpKey}
+
+// Redis is the network backend. Every node points at the same Redis.
+type Redis struct {
+	rdb *redis.Client
+}
+
+// NewRedis connects to a redis:// URL and checks the connection.
+func NewRedis(url string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+	return &Redis{rdb: rdb}, nil
+}
+
+func (r *Redis) Close() error {
+	return r.rdb.Close()
+}
+
+func (r *Redis) PutGame(ctx context.Context, route Route, players []string) error {
+	data, err := json.Marshal(route)
+	if err != nil {
+		return err
+	}
+	pipe := r.rdb.TxPipeline()
+	pipe.Set(ctx, gameKey(route.GameID), route.Owner, routeTTL)
+	for _, p := range players {
+		pipe.Set(ctx, playerKey(p), data, routeTTL)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) Owner(ctx context.Context, gameID string) (string, error) {
+	owner, err := r.rdb.Get(ctx, gameKey(gameID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return owner, err
+}
+
+func (r *Redis) FindByPlayer(ctx context.Context, username string) (*Route, error) {
+	data, err := r.rdb.Get(ctx, playerKey(username)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var route Route
+	if err := json.Unmarshal(data, &route); err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
+
+func (r *Redis) DeleteGame(ctx context.Context, gameID string, players []string) error {
+	keys := []string{gameKey(gameID)}
+	for _, p := range players {
+		// Leave the route alone if the player has already moved on
+		if route, err := r.FindByPlayer(ctx, p); err == nil && route != nil && route.GameID == gameID {
+			keys = append(keys, playerKey(p))
+		}
+	}
+	return r.rdb.Del(ctx, keys...).Err()
+}
+
+func (r *Redis) Match(ctx context.Context, t Ticket) (*Ticket, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	res, err := matchScript.Run(ctx, r.rdb, queueKeys, t.Username, data,
+		now.UnixMilli(), t.Since.Add(TicketTTL).UnixMilli()).Text()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil // queued
+	}
+	if err != nil {
+		return nil, err
+	}
+	var opp Ticket
+	if err := json.Unmarshal([]byte(res), &opp); err != nil {
+		return nil, err
+	}
+	return &opp, nil
+}
+
+func (r *Redis) Leave(ctx context.Context, username string) (bool, error) {
+	n, err := leaveScript.Run(ctx, r.rdb, queueKeys, username).Int()
+	return n > 0, err
+}
+
+func (r *Redis) Publish(ctx context.Context, channel string, msg []byte) error {
+	return r.rdb.Publish(ctx, channel, msg).Err()
+}
+
+func (r *Redis) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	sub := r.rdb.Subscribe(ctx, channel)
+	// Wait for the confirmation so nothing published after we return is lost
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	out := make(chan []byte, 256)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		msgs := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-msgs:
+				if !ok {
+					return
+				}
+				out <- []byte(m.Payload)
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,235 @@
+// Command arena plays engines against each other to measure bot changes.
+//
+//	go run ./cmd/arena -a search-8 -b heuristic -games 200 -out results.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"fourinrow/game"
+	"fourinrow/game/bot"
+)
+
+// GameResult is one arena game, scored from engine A's point of view.
+type GameResult struct {
+	Index   int     `json:"index"`
+	Opening []int   `json:"opening"`
+	AColor  int     `json:"aColor"`
+	Score   float64 `json:"score"` // 1 win, 0.5 draw, 0 loss
+	Plies   int     `json:"plies"`
+	Reason  string  `json:"reason,omitempty"`
+}
+
+// Summary is what gets written to the JSON output.
+type Summary struct {
+	EngineA    string       `json:"engineA"`
+	EngineB    string       `json:"engineB"`
+	Tally      Tally        `json:"tally"`
+	Score      float64      `json:"score"`
+	Elo        float64      `json:"elo"`
+	EloLow     float64      `json:"eloLow"`
+	EloHigh    float64      `json:"eloHigh"`
+	SPRT       *SPRT        `json:"sprt,omitempty"`
+	DurationMs int64        `json:"durationMs"`
+	Games      []GameResult `json:"games"`
+}
+
+func main() {
+	engineA := flag.String("a", "default", "first engine")
+	engineB := flag.String("b", "heuristic", "second engine")
+	games := flag.Int("games", 100, "number of games (rounded up to an even number)")
+	workers := flag.Int("concurrency", runtime.NumCPU(), "games played in parallel")
+	openingPlies := flag.Int("opening-plies", 2, "random plies played before the engines take over")
+	seed := flag.Int64("seed", 1, "seed for opening selection")
+	out := flag.String("out", "", "write the JSON summary to this file (default stdout)")
+	sprt := flag.Bool("sprt", false, "stop early once an SPRT concludes")
+	elo0 := flag.Float64("elo0", 0, "SPRT null hypothesis (Elo)")
+	elo1 := flag.Float64("elo1", 20, "SPRT alternative hypothesis (Elo)")
+	alpha := flag.Float64("alpha", 0.05, "SPRT false positive rate")
+	beta := flag.Float64("beta", 0.05, "SPRT false negative rate")
+	flag.Parse()
+
+	// Validate engine names up front
+	for _, name := range []string{*engineA, *engineB} {
+		if _, err := bot.NewEngine(name); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Each opening is played twice with colours swapped
+	n := (*games + 1) / 2 * 2
+	openings := makeOpenings(n/2, *openingPlies, *seed)
+
+	summary := Summary{EngineA: *engineA, EngineB: *engineB}
+	if *sprt {
+		summary.SPRT = NewSPRT(*elo0, *elo1, *alpha, *beta)
+	}
+
+	start := time.Now()
+	jobs := make(chan int)
+	results := make(chan GameResult)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Engines are per worker so their tables are never shared
+			a, _ := bot.NewEngine(*engineA)
+			b, _ := bot.NewEngine(*engineB)
+			for i := range jobs {
+				results <- playGame(i, a, b, openings[i/2], i%2 == 0)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := 0; i < n; i++ {
+			select {
+			case jobs <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	stopped := false
+	for r := range results {
+		summary.Games = append(summary.Games, r)
+		switch r.Score {
+		case 1:
+			summary.Tally.Wins++
+		case 0.5:
+			summary.Tally.Draws++
+		default:
+			summary.Tally.Losses++
+		}
+
+		t := summary.Tally
+		if t.Games()%10 == 0 {
+			log.Printf("[ARENA] %d/%d  +%d =%d -%d", t.Games(), n, t.Wins, t.Draws, t.Losses)
+		}
+		if summary.SPRT != nil && !stopped && summary.SPRT.Update(t) {
+			log.Printf("[ARENA] SPRT accepted %s after %d games (LLR %.2f)", summary.SPRT.Result, t.Games(), summary.SPRT.LLR)
+			stopped = true
+			close(stop)
+		}
+	}
+
+	summary.DurationMs = time.Since(start).Milliseconds()
+	summary.Score = summary.Tally.Score()
+	summary.Elo, summary.EloLow, summary.EloHigh = summary.Tally.EloInterval()
+
+	t := summary.Tally
+	fmt.Fprintf(os.Stderr, "%s vs %s: +%d =%d -%d (%.1f%%)  Elo %+.1f [%+.1f, %+.1f]\n",
+		*engineA, *engineB, t.Wins, t.Draws, t.Losses, summary.Score*100,
+		summary.Elo, summary.EloLow, summary.EloHigh)
+
+	if err := writeSummary(*out, &summary); err != nil {
+		log.Fatalf("Failed to write results: %v", err)
+	}
+}
+
+// makeOpenings picks `count` distinct random openings that do not already
+// decide the game. If there are fewer distinct openings than requested they
+// are reused.
+func makeOpenings(count, plies int, seed int64) [][]int {
+	rng := rand.New(rand.NewSource(seed))
+	seen := make(map[string]bool)
+	var openings [][]int
+
+	for attempts := 0; len(openings) < count && attempts < count*50; attempts++ {
+		moves := make([]int, plies)
+		for i := range moves {
+			moves[i] = rng.Intn(7)
+		}
+		key := fmt.Sprint(moves)
+		if seen[key] {
+			continue
+		}
+		g, err := game.Replay(moves)
+		if err != nil || g.Status != "playing" {
+			continue
+		}
+		seen[key] = true
+		openings = append(openings, moves)
+	}
+
+	if len(openings) == 0 {
+		openings = append(openings, []int{})
+	}
+	for i := 0; len(openings) < count; i++ {
+		openings = append(openings, openings[i])
+	}
+	return openings
+}
+
+// playGame plays one game using the game package's rules directly.
+func playGame(index int, a, b bot.Engine, opening []int, aFirst bool) GameResult {
+	res := GameResult{Index: index, Opening: opening, AColor: 2}
+	if aFirst {
+		res.AColor = 1
+	}
+
+	g, _ := game.Replay(opening)
+	g.ID = fmt.Sprintf("arena-%d", index)
+
+	engines := map[int]bot.Engine{res.AColor: a, 3 - res.AColor: b}
+	ids := map[int]string{1: "p1", 2: "p2"}
+
+	for g.Status == "playing" {
+		color := 1
+		if g.CurrentTurn == ids[2] {
+			color = 2
+		}
+
+		col, err := engines[color].BestMove(g, color)
+		if err == nil {
+			err = game.ApplyMove(g, ids[color], col)
+		}
+		if err != nil {
+			// An engine that cannot produce a legal move forfeits
+			res.Reason = fmt.Sprintf("%s forfeits: %v", engines[color].Name(), err)
+			g.Status = "finished"
+			g.Winner = ids[3-color]
+		}
+	}
+
+	res.Plies = len(g.Moves)
+	switch g.Winner {
+	case "draw":
+		res.Score = 0.5
+	case ids[res.AColor]:
+		res.Score = 1
+	}
+	return res
+}
+
+func writeSummary(path string, s *Summary) error {
+	w := os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
@@ -0,0 +1,95 @@
+package main
+
+import "math"
+
+// Tally is a win/draw/loss count from engine A's point of view.
+type Tally struct {
+	Wins   int `json:"wins"`
+	Draws  int `json:"draws"`
+	Losses int `json:"losses"`
+}
+
+func (t Tally) Games() int { return t.Wins + t.Draws + t.Losses }
+
+// Score is A's average points per game (win 1, draw 0.5).
+func (t Tally) Score() float64 {
+	n := t.Games()
+	if n == 0 {
+		return 0.5
+	}
+	return (float64(t.Wins) + 0.5*float64(t.Draws)) / float64(n)
+}
+
+// variance is the per-game variance of A's score.
+func (t Tally) variance() float64 {
+	n := float64(t.Games())
+	if n == 0 {
+		return 0
+	}
+	s := t.Score()
+	w, d, l := float64(t.Wins)/n, float64(t.Draws)/n, float64(t.Losses)/n
+	return w*(1-s)*(1-s) + d*(0.5-s)*(0.5-s) + l*s*s
+}
+
+// EloDiff converts a score to an Elo difference. Perfect scores are clamped so
+// the result stays finite.
+func EloDiff(score float64) float64 {
+	const eps = 1e-3
+	score = math.Max(eps, math.Min(1-eps, score))
+	return -400 * math.Log10(1/score-1)
+}
+
+// expectedScore is the inverse of EloDiff.
+func expectedScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// EloInterval returns the Elo difference with a 95% confidence interval.
+func (t Tally) EloInterval() (elo, low, high float64) {
+	n := float64(t.Games())
+	s := t.Score()
+	if n == 0 {
+		return 0, 0, 0
+	}
+	margin := 1.96 * math.Sqrt(t.variance()/n)
+	return EloDiff(s), EloDiff(s - margin), EloDiff(s + margin)
+}
+
+// SPRT tests H0: elo <= Elo0 against H1: elo >= Elo1.
+type SPRT struct {
+	Elo0   float64 `json:"elo0"`
+	Elo1   float64 `json:"elo1"`
+	Alpha  float64 `json:"alpha"`
+	Beta   float64 `json:"beta"`
+	LLR    float64 `json:"llr"`
+	Lower  float64 `json:"lower"`
+	Upper  float64 `json:"upper"`
+	Result string  `json:"result"` // "H0", "H1" or "" while undecided
+}
+
+func NewSPRT(elo0, elo1, alpha, beta float64) *SPRT {
+	return &SPRT{
+		Elo0: elo0, Elo1: elo1, Alpha: alpha, Beta: beta,
+		Lower: math.Log(beta / (1 - alpha)),
+		Upper: math.Log((1 - beta) / alpha),
+	}
+}
+
+// Update recomputes the log-likelihood ratio using the normal approximation
+// of the trinomial model and reports whether the test has concluded.
+func (p *SPRT) Update(t Tally) bool {
+	v := t.variance()
+	if t.Games() < 2 || v == 0 {
+		return false
+	}
+	s0, s1 := expectedScore(p.Elo0), expectedScore(p.Elo1)
+	p.LLR = float64(t.Games()) * (s1 - s0) * (2*t.Score() - s0 - s1) / (2 * v)
+
+	switch {
+	case p.LLR >= p.Upper:
+		p.Result = "H1"
+	case p.LLR <= p.Lower:
+		p.Result = "H0"
+	}
+	return p.Result != ""
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// deadLetter is a message the consumer could not process, with the reason.
+// The file and the topic hold the same JSON, one record per line/message.
+type deadLetter struct {
+	Reason    string    `json:"reason"`
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Key       string    `json:"key,omitempty"`
+	FailedAt  time.Time `json:"failed_at"`
+	Value     string    `json:"value"` // the original message
+}
+
+func newDeadLetter(msg *sarama.ConsumerMessage, reason error) deadLetter {
+	return deadLetter{
+		Reason:    reason.Error(),
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       string(msg.Key),
+		FailedAt:  time.Now().UTC(),
+		Value:     string(msg.Value),
+	}
+}
+
+// message rebuilds the original message for a replay.
+func (d deadLetter) message() *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:     d.Topic,
+		Partition: d.Partition,
+		Offset:    d.Offset,
+		Key:       []byte(d.Key),
+		Value:     []byte(d.Value),
+	}
+}
+
+type deadLetterSink interface {
+	Write(d deadLetter) error
+	Close() error
+}
+
+// fileDLQ appends dead letters to a JSON-lines file. It opens the file for
+// each write, so replay-dlq can move the file aside while the consumer runs.
+type fileDLQ struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (f *fileDLQ) Write(d deadLetter) error {
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *fileDLQ) Close() error { return nil }
+
+// kafkaDLQ publishes dead letters to a topic, keyed like the original.
+type kafkaDLQ struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func newKafkaDLQ(brokers []string, topic string) (*kafkaDLQ, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	p, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaDLQ{producer: p, topic: topic}, nil
+}
+
+func (k *kafkaDLQ) Write(d deadLetter) error {
+	value, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	msg := &sarama.ProducerMessage{Topic: k.topic, Value: sarama.ByteEncoder(value)}
+	if d.Key != "" {
+		msg.Key = sarama.StringEncoder(d.Key)
+	}
+	_, _, err = k.producer.SendMessage(msg)
+	return err
+}
+
+func (k *kafkaDLQ) Close() error { return k.producer.Close() }
+
+// replayTally counts what a replay did with the dead letters.
+type replayTally map[outcome]int
+
+func (t replayTally) String() string {
+	return fmt.Sprintf("%d processed, %d duplicates, %d dead-lettered again", t[processed], t[duplicate], t[deadLettered])
+}
+
+// replayDLQ runs `consumer replay-dlq`: it reprocesses the dead letters
+// through processMessage, after a fix has been deployed. Letters that fail
+// again go back to the same queue.
+func replayDLQ(args []string) {
+	fs := flag.NewFlagSet("replay-dlq", flag.ExitOnError)
+	shared := addSharedFlags(fs)
+	group := fs.String("group", envOr("KAFKA_GROUP", "fourinrow-analytics")+"-dlq-replay", "group whose offsets track the replay of -dlq-topic")
+	fs.Parse(args)
+
+	c := shared.open()
+	defer c.close()
+
+	var tally replayTally
+	var err error
+	switch {
+	case *shared.dlqFile != "":
+		tally, err = replayFile(c, *shared.dlqFile)
+	case *shared.dlqTopic != "":
+		tally, err = replayTopic(c, shared.brokerList(), *shared.dlqTopic, *group)
+	default:
+		log.Fatal("replay-dlq needs -dlq-file or -dlq-topic")
+	}
+	log.Printf("Replay: %s", tally)
+	if err != nil {
+		log.Fatalf("Replay stopped: %v", err)
+	}
+}
+
+// replayFile moves the file aside to PATH.replaying, so new dead letters
+// (including the ones that fail again) start a fresh file, then replays it.
+// A replay that stops early leaves PATH.replaying, and the next run resumes
+// it; letters already replayed are skipped as duplicates.
+func replayFile(c *consumer, path string) (replayTally, error) {
+	tally := replayTally{}
+	replaying := path + ".replaying"
+	if _, err := os.Stat(replaying); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(path, replaying); errors.Is(err, os.ErrNotExist) {
+			log.Printf("No dead letters in %s", path)
+			return tally, nil
+		} else if err != nil {
+			return tally, err
+		}
+	} else {
+		log.Printf("Resuming %s", replaying)
+	}
+
+	err := scanLines(replaying, func(line int, data []byte) error {
+		var d deadLetter
+		if err := json.Unmarshal(data, &d); err != nil {
+			log.Printf("%s:%d: not a dead letter: %v", replaying, line, err)
+			return nil
+		}
+		res, err := c.processMessage(d.message())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", replaying, line, err)
+		}
+		tally[res]++
+		return nil
+	})
+	if err != nil {
+		return tally, err
+	}
+	return tally, os.Remove(replaying)
+}
+
+// replayTopic replays the topic's partitions from the replay group's
+// committed offsets up to where they ended when the replay started, so the
+// letters that fail again are left for the next run.
+func replayTopic(c *consumer, brokers []string, topic, group string) (replayTally, error) {
+	tally := replayTally{}
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return tally, err
+	}
+	defer client.Close()
+
+	offsets, err := sarama.NewOffsetManagerFromClient(group, client)
+	if err != nil {
+		return tally, err
+	}
+	defer offsets.Close()
+	cons, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return tally, err
+	}
+	defer cons.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return tally, err
+	}
+	for _, p := range partitions {
+		if err := replayPartition(c, client, cons, offsets, topic, p, tally); err != nil {
+			return tally, err
+		}
+	}
+	return tally, nil
+}
+
+func replayPartition(c *consumer, client sarama.Client, cons sarama.Consumer, offsets sarama.OffsetManager, topic string, partition int32, tally replayTally) error {
+	pom, err := offsets.ManagePartition(topic, partition)
+	if err != nil {
+		return err
+	}
+	defer pom.Close()
+
+	end, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+	next, _ := pom.NextOffset()
+	if next == sarama.OffsetOldest {
+		if next, err = client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+			return err
+		}
+	}
+	if next >= end {
+		return nil
+	}
+
+	pc, err := cons.ConsumePartition(topic, partition, next)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	for msg := range pc.Messages() {
+		var d deadLetter
+		if err := json.Unmarshal(msg.Value, &d); err != nil {
+			log.Printf("%s/%d@%d: not a dead letter: %v", topic, partition, msg.Offset, err)
+		} else {
+			res, err := c.processMessage(d.message())
+			if err != nil {
+				return fmt.Errorf("%s/%d@%d: %w", topic, partition, msg.Offset, err)
+			}
+			tally[res]++
+		}
+		pom.MarkOffset(msg.Offset+1, "")
+		if msg.Offset+1 >= end {
+			break
+		}
+	}
+	return nil
+}
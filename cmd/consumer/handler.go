@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// handler processes the partitions the group assigns to this consumer.
+// Sarama calls ConsumeClaim in its own goroutine for each partition.
+type handler struct {
+	c *consumer
+}
+
+// Setup runs after a rebalance, before any claim is consumed.
+func (h *handler) Setup(session sarama.ConsumerGroupSession) error {
+	log.Printf("🔀 Assigned partitions %v (generation %d)", session.Claims(), session.GenerationID())
+	if h.c.windows != nil {
+		var partitions []int32
+		for _, ps := range session.Claims() {
+			partitions = append(partitions, ps...)
+		}
+		h.c.windows.assign(partitions)
+	}
+	return nil
+}
+
+// Cleanup runs when the session ends, after every ConsumeClaim has returned
+// and before the final offsets are committed.
+func (h *handler) Cleanup(session sarama.ConsumerGroupSession) error {
+	log.Printf("🔀 Releasing partitions %v", session.Claims())
+	return nil
+}
+
+func (h *handler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil // rebalancing
+			}
+			if !h.process(session, msg) {
+				return nil
+			}
+			// Only processed events are committed, so a crash replays the rest
+			session.MarkMessage(msg, "")
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// process retries msg until it is saved or dead-lettered, backing off up to a
+// minute so an outage holds the partition rather than skipping events. It
+// returns false if the session ends first.
+func (h *handler) process(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) bool {
+	backoff := time.Second
+	for {
+		_, err := h.c.processMessage(msg)
+		if err == nil {
+			return true
+		}
+		log.Printf("Failed to process %s/%d@%d, retrying in %s: %v", msg.Topic, msg.Partition, msg.Offset, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-session.Context().Done():
+			return false
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
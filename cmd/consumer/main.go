@@ -1,114 +1,322 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"fourinrow/db"
+	"fourinrow/events"
+
 	"github.com/IBM/sarama"
 )
 
-type GameEvent struct {
-	Type      string      `json:"type"`
-	GameID    string      `json:"game_id"`
-	PlayerID  string      `json:"player_id"`
-	Timestamp int64       `json:"timestamp"`
-	Payload   interface{} `json:"payload"`
-}
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay-dlq":
+			replayDLQ(os.Args[2:])
+			return
+		case "replay":
+			replay(os.Args[2:])
+			return
+		case "windows":
+			windowsFromFiles(os.Args[2:])
+			return
+		}
+	}
 
-// In-Memory Stats
-var (
-	gameStartTimes = make(map[string]int64)
-	durations      = []float64{}
-	winCounts      = make(map[string]int)
-	gamesPerHour   = make(map[string]int)
-)
+	shared := addSharedFlags(flag.CommandLine)
+	topic := flag.String("topic", envOr("KAFKA_TOPIC", "game-events"), "topic to consume (KAFKA_TOPIC)")
+	group := flag.String("group", envOr("KAFKA_GROUP", "fourinrow-analytics"), "consumer group (KAFKA_GROUP)")
+	from := flag.String("from", envOr("KAFKA_FROM", "oldest"), "where a group with no committed offset starts: oldest or newest (KAFKA_FROM)")
+	addr := flag.String("http", envOr("STATS_ADDR", ":8090"), "address of the /stats API (STATS_ADDR)")
+	window := flag.Duration("dedupe-window", envDuration("DEDUPE_WINDOW", 24*time.Hour), "how long event IDs are remembered to drop redeliveries (DEDUPE_WINDOW)")
+	shared.ephemeral = flag.Bool("ephemeral", false, "keep the rollups in memory and commit no offsets, so every start reads the topic from the oldest event")
+	wcfg := addWindowFlags(flag.CommandLine)
+	flag.Parse()
+	if err := wcfg.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if *from != "oldest" && *from != "newest" {
+		log.Fatalf("Invalid -from %q: want oldest or newest", *from)
+	}
 
-func main() {
-	brokers := []string{"localhost:9092"}
-	topic := "game-events"
+	// Rollups outlive the process, so a restart carries on from the
+	// committed offsets with the totals intact (except with -ephemeral)
+	c := shared.open()
+	defer c.close()
+	go pruneSeenEvents(c.store, *window)
+	// Windowed metrics live in memory and start afresh on restart
+	c.windows = newWindows(*wcfg)
+
+	srv := &http.Server{Addr: *addr, Handler: statsMux(c.store, c.windows)}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Stats API failed: %v", err)
+		}
+	}()
+	log.Printf("📊 Serving /stats on %s", *addr)
 
 	config := sarama.NewConfig()
 	config.Consumer.Return.Errors = true
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategySticky()}
+	// Offsets are committed in the background and on Close; a restart picks
+	// up after the last committed event
+	config.Consumer.Offsets.AutoCommit.Enable = true
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	if *from == "newest" {
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	}
+	if *shared.ephemeral {
+		// The totals die with the process, so the offsets must too: a group
+		// of its own, never committed, read from the start
+		config.Consumer.Offsets.AutoCommit.Enable = false
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+		*group += "-ephemeral-" + events.NewID()[:8]
+	}
 
-	// Create Consumer
-	consumer, err := sarama.NewConsumer(brokers, config)
+	// Create Consumer Group
+	consumerGroup, err := sarama.NewConsumerGroup(shared.brokerList(), *group, config)
 	if err != nil {
 		log.Fatalf("Failed to start consumer: %v", err)
 	}
-	defer consumer.Close()
+	go func() {
+		for err := range consumerGroup.Errors() {
+			log.Printf("Error: %v", err)
+		}
+	}()
 
-	// Consume Partition 0
-	partitionConsumer, err := consumer.ConsumePartition(topic, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Fatalf("Failed to listen to topic: %v", err)
+	// Handle Exit (Ctrl+C, or SIGTERM from the orchestrator)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("📊 Analytics Service Started. Listening for events on %s as group %s...", *topic, *group)
+
+	// Consume returns at every rebalance, so join again until we are told to stop
+	for {
+		if err := consumerGroup.Consume(ctx, []string{*topic}, &handler{c: c}); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				break
+			}
+			log.Printf("Error: %v", err)
+			time.Sleep(time.Second)
+		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
-	defer partitionConsumer.Close()
 
-	log.Println("📊 Analytics Service Started. Listening for events...")
+	log.Println("Shutting down analytics...")
+	// Close commits the offsets of everything processed
+	if err := consumerGroup.Close(); err != nil {
+		log.Printf("Error closing consumer group: %v", err)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+}
+
+// sharedFlags are the flags of both the consumer and replay-dlq.
+type sharedFlags struct {
+	brokers, driver, dsn, dlqTopic, dlqFile *string
+	ephemeral                               *bool // nil where there is no -ephemeral
+}
 
-	// Handle Exit (Ctrl+C)
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
+func addSharedFlags(fs *flag.FlagSet) *sharedFlags {
+	return &sharedFlags{
+		brokers:  fs.String("brokers", envOr("KAFKA_BROKERS", envOr("KAFKA_BROKER", "localhost:9092")), "comma-separated Kafka brokers (KAFKA_BROKERS)"),
+		driver:   fs.String("db-driver", os.Getenv("DB_DRIVER"), "rollup store: postgres, sqlite or memory (DB_DRIVER)"),
+		dsn:      fs.String("database", os.Getenv("DATABASE_URL"), "rollup database URL or SQLite path (DATABASE_URL)"),
+		dlqTopic: fs.String("dlq-topic", os.Getenv("DLQ_TOPIC"), "dead-letter topic for events that can't be processed (DLQ_TOPIC)"),
+		dlqFile:  fs.String("dlq-file", os.Getenv("DLQ_FILE"), "dead-letter JSON-lines file, instead of a topic (DLQ_FILE)"),
+	}
+}
+
+func (f *sharedFlags) brokerList() []string {
+	return strings.Split(*f.brokers, ",")
+}
+
+// open connects the rollup store and the dead-letter sink. The memory
+// store is refused unless -ephemeral is set: the committed offsets would
+// outlive the totals, and a restart would carry on from them with nothing.
+func (f *sharedFlags) open() *consumer {
+	if isMemory(*f.driver, *f.dsn) && (f.ephemeral == nil || !*f.ephemeral) {
+		log.Fatal("No rollup database configured: set -db-driver and -database (DB_DRIVER, DATABASE_URL), or -ephemeral to keep the rollups in memory")
+	}
+	store, err := db.OpenRollups(*f.driver, *f.dsn)
+	if err != nil {
+		log.Fatalf("Failed to open rollup store: %v", err)
+	}
+	c := &consumer{store: store}
+
+	switch {
+	case *f.dlqTopic != "" && *f.dlqFile != "":
+		log.Fatal("Set -dlq-topic or -dlq-file, not both")
+	case *f.dlqTopic != "":
+		if c.dlq, err = newKafkaDLQ(f.brokerList(), *f.dlqTopic); err != nil {
+			log.Fatalf("Failed to start dead-letter producer: %v", err)
+		}
+	case *f.dlqFile != "":
+		c.dlq = &fileDLQ{path: *f.dlqFile}
+	default:
+		log.Println("⚠️  No dead-letter queue configured: events that can't be processed are logged and dropped")
+	}
+	return c
+}
 
-	// Loop forever
+// pruneSeenEvents forgets event IDs, and the games the rollups have counted,
+// older than the dedupe window, hourly.
+func pruneSeenEvents(store db.RollupStore, window time.Duration) {
 	for {
-		select {
-		case msg := <-partitionConsumer.Messages():
-			processMessage(msg.Value)
-		case err := <-partitionConsumer.Errors():
-			log.Printf("Error: %v", err)
-		case <-signals:
-			log.Println("Shutting down analytics...")
-			return
+		cutoff := time.Now().Add(-window)
+		if n, err := store.PruneSeenEvents(cutoff); err != nil {
+			log.Printf("[DB ERROR] Failed to prune seen events: %v", err)
+		} else if n > 0 {
+			log.Printf("🧹 Forgot %d event IDs older than %s", n, window)
+		}
+		if n, err := store.PruneGameLog(cutoff); err != nil {
+			log.Printf("[DB ERROR] Failed to prune the game log: %v", err)
+		} else if n > 0 {
+			log.Printf("🧹 Forgot %d games finished more than %s ago", n, window)
 		}
+		time.Sleep(time.Hour)
 	}
 }
 
-func processMessage(value []byte) {
-	var event GameEvent
-	if err := json.Unmarshal(value, &event); err != nil {
-		log.Printf("Invalid JSON: %v", err)
-		return
-	}
-
-	// 1. Track Games Per Hour
-	t := time.Unix(event.Timestamp, 0)
-	timeKey := t.Format("2006-01-02 15:00")
-	gamesPerHour[timeKey]++
-
-	// --- NEW: Print the Games Per Hour Stat ---
-	fmt.Printf("📈 Games in hour %s: %d\n", timeKey, gamesPerHour[timeKey])
-	// ------------------------------------------
-
-	switch event.Type {
-	case "game_started":
-		gameStartTimes[event.GameID] = event.Timestamp
-		fmt.Printf("[EVENT] Game Started: %s (Type: %v)\n", event.GameID, event.Payload)
-
-	case "game_finished":
-		// Calculate Duration
-		if startTime, exists := gameStartTimes[event.GameID]; exists {
-			duration := float64(event.Timestamp - startTime)
-			durations = append(durations, duration)
-			delete(gameStartTimes, event.GameID)
-
-			var total float64
-			for _, d := range durations {
-				total += d
-			}
-			avg := total / float64(len(durations))
-			fmt.Printf("⏱️  Game Over. Duration: %.0fs | Avg Duration: %.1fs\n", duration, avg)
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid %s %q: %v", key, v, err)
 		}
+		return d
+	}
+	return fallback
+}
+
+// consumer turns messages into rollups.
+type consumer struct {
+	store   db.RollupStore
+	dlq     deadLetterSink // nil drops poison messages
+	windows *windows       // nil skips the windowed metrics
+	quiet   bool           // don't print each game
+}
 
-		// Track Wins
-		winner := fmt.Sprintf("%v", event.Payload)
-		if winner != "" && winner != "draw" {
-			winCounts[winner]++
-			fmt.Printf("🏆 Winner: %s | Total Wins: %d\n", winner, winCounts[winner])
+func (c *consumer) close() {
+	if c.dlq != nil {
+		c.dlq.Close()
+	}
+	c.store.Close()
+}
+
+// Outcomes of processMessage
+type outcome int
+
+const (
+	processed outcome = iota
+	duplicate
+	deadLettered
+)
+
+// processMessage adds one event to the rollups. Events that don't decode go
+// to the dead-letter queue, and events already seen are skipped. An error
+// means the store or the dead-letter queue failed and the message should be
+// retried.
+func (c *consumer) processMessage(msg *sarama.ConsumerMessage) (outcome, error) {
+	event, err := events.Decode(msg.Value)
+	if err != nil {
+		return deadLettered, c.deadLetter(msg, err)
+	}
+
+	seen, err := c.store.EventSeen(event.ID)
+	if err != nil {
+		return processed, err
+	}
+	if seen {
+		log.Printf("Skipping duplicate %s event %s", event.Type, event.ID)
+		return duplicate, nil
+	}
+
+	if err := c.apply(event); err != nil {
+		return processed, err
+	}
+	// A crash before this line replays the event; the rollups count each
+	// game's start and finish once anyway
+	if err := c.store.MarkEventSeen(event.ID, time.Now()); err != nil {
+		return processed, err
+	}
+	if c.windows != nil {
+		c.windows.add(msg.Partition, event)
+	}
+	return processed, nil
+}
+
+func (c *consumer) deadLetter(msg *sarama.ConsumerMessage, reason error) error {
+	if c.dlq == nil {
+		log.Printf("Invalid event at %s/%d@%d: %v", msg.Topic, msg.Partition, msg.Offset, reason)
+		return nil
+	}
+	log.Printf("☠️  Dead-lettering %s/%d@%d: %v", msg.Topic, msg.Partition, msg.Offset, reason)
+	return c.dlq.Write(newDeadLetter(msg, reason))
+}
+
+// apply adds a decoded event to the rollups.
+func (c *consumer) apply(event events.Event) error {
+	at := time.Unix(event.Timestamp, 0).UTC()
+
+	switch p := event.Payload.(type) {
+	case events.GameStartedPayload:
+		if err := c.store.RecordGameStarted(db.StartedGame{GameID: event.GameID, Mode: p.Mode, At: at}); err != nil {
+			return err
+		}
+		if !c.quiet {
+			fmt.Printf("[EVENT] Game Started: %s (Type: %s)\n", event.GameID, p.Mode)
+		}
+
+	case events.GameFinishedPayload:
+		// Wins are counted by username; events from before usernames only
+		// have the winner's per-game player ID
+		var winner string
+		if p.Winner != nil {
+			winner = p.Winner.Username
+			if winner == "" {
+				winner = p.Winner.PlayerID
+			}
+		}
+		err := c.store.RecordGameFinished(db.FinishedGame{
+			GameID:   event.GameID,
+			Mode:     p.Mode,
+			Winner:   winner,
+			Duration: time.Duration(p.DurationMs) * time.Millisecond,
+			At:       at,
+		})
+		if err != nil {
+			return err
+		}
+		if c.quiet {
+			break
+		}
+		if winner != "" {
+			fmt.Printf("🏆 Game Over: %s | Winner: %s\n", event.GameID, winner)
+		} else {
+			fmt.Printf("🤝 Game Over: %s | Draw\n", event.GameID)
 		}
 	}
-}
\ No newline at end of file
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"fourinrow/db"
+
+	"github.com/IBM/sarama"
+)
+
+// allTime bounds the rollup queries of a diff.
+var allTime = [2]time.Time{time.Unix(0, 0).UTC(), time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+// replay runs `consumer replay`: it rebuilds the rollups by running past
+// events through processMessage into a fresh store, then diffs the result
+// against the current one. The events come from JSON-lines archives named
+// as arguments, or else from the topic, starting at -offset or -since on
+// every partition and stopping at the end the partition had when the replay
+// started. The live consumer group's offsets are not touched.
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	brokers := fs.String("brokers", envOr("KAFKA_BROKERS", envOr("KAFKA_BROKER", "localhost:9092")), "comma-separated Kafka brokers (KAFKA_BROKERS)")
+	topic := fs.String("topic", envOr("KAFKA_TOPIC", "game-events"), "topic to replay (KAFKA_TOPIC)")
+	offset := fs.Int64("offset", -1, "first offset to replay on each partition; -1 for the oldest")
+	since := fs.String("since", "", "replay from the first message with a Kafka timestamp at or after this time (YYYY-MM-DD or RFC 3339)")
+	driver := fs.String("db-driver", os.Getenv("DB_DRIVER"), "current rollup store to diff against (DB_DRIVER)")
+	dsn := fs.String("database", os.Getenv("DATABASE_URL"), "current rollup database URL or SQLite path (DATABASE_URL)")
+	toDriver := fs.String("to-driver", "memory", "store to rebuild into: postgres, sqlite or memory")
+	toDSN := fs.String("to-database", "", "database URL or SQLite path to rebuild into; it must have no rollups yet")
+	dryRun := fs.Bool("dry-run", false, "rebuild in memory and only print the diff")
+	fs.Parse(args)
+
+	if *dryRun {
+		*toDriver, *toDSN = "memory", ""
+	} else if isMemory(*toDriver, *toDSN) {
+		log.Fatal("A rebuild into memory is thrown away: set -to-driver and -to-database, or -dry-run")
+	}
+	// An in-memory current store would be empty and differ everywhere
+	diff := !isMemory(*driver, *dsn)
+	if *dryRun && !diff {
+		log.Fatal("A dry run only prints the diff: set -db-driver and -database (DB_DRIVER, DATABASE_URL) to the current rollups")
+	}
+
+	target, err := db.OpenRollups(*toDriver, *toDSN)
+	if err != nil {
+		log.Fatalf("Failed to open the store to rebuild into: %v", err)
+	}
+	defer target.Close()
+	if ok, err := rollupsEmpty(target); err != nil {
+		log.Fatalf("Failed to check the store to rebuild into: %v", err)
+	} else if !ok {
+		log.Fatal("The store to rebuild into already has rollups; replay needs a fresh one")
+	}
+	c := &consumer{store: target, quiet: true}
+
+	var tally replayTally
+	if fs.NArg() > 0 {
+		tally, err = replayArchives(c, fs.Args())
+	} else {
+		var from time.Time
+		if from, err = parseDay(*since); err != nil {
+			log.Fatalf("Invalid -since %q: %v", *since, err)
+		}
+		tally, err = replayRange(c, strings.Split(*brokers, ","), *topic, *offset, from)
+	}
+	log.Printf("Replay: %d processed, %d duplicates, %d invalid", tally[processed], tally[duplicate], tally[deadLettered])
+	if err != nil {
+		log.Fatalf("Replay stopped: %v", err)
+	}
+
+	if !diff {
+		fmt.Println("Rebuilt; no current store given (-db-driver, -database), so no diff")
+		return
+	}
+	current, err := db.OpenRollups(*driver, *dsn)
+	if err != nil {
+		log.Fatalf("Failed to open the current rollup store: %v", err)
+	}
+	defer current.Close()
+	n, err := diffRollups(os.Stdout, current, target)
+	if err != nil {
+		log.Fatalf("Diff failed: %v", err)
+	}
+	fmt.Printf("%d differences from the current rollups\n", n)
+	if *dryRun {
+		fmt.Println("Dry run: nothing was written")
+	}
+}
+
+// isMemory reports whether the driver and URL pick the memory store, as
+// db.OpenRollups resolves them.
+func isMemory(driver, dsn string) bool {
+	return driver == "memory" || (driver == "" && dsn == "")
+}
+
+// rollupsEmpty reports whether a store has no game rollups or wins.
+func rollupsEmpty(store db.RollupStore) (bool, error) {
+	games, err1 := store.GameBuckets(db.RollupDay, allTime[0], allTime[1])
+	wins, err2 := store.TopWinners(1)
+	return len(games) == 0 && len(wins) == 0, errors.Join(err1, err2)
+}
+
+// replayArchives processes JSON-lines event files in order. Each event is
+// handed over as a message whose topic is the file and whose offset is the
+// line, so log lines point at the source.
+func replayArchives(c *consumer, paths []string) (replayTally, error) {
+	tally := replayTally{}
+	for _, path := range paths {
+		err := scanLines(path, func(line int, data []byte) error {
+			msg := &sarama.ConsumerMessage{Topic: path, Offset: int64(line), Value: append([]byte(nil), data...)}
+			res, err := c.processMessage(msg)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			tally[res]++
+			return nil
+		})
+		if err != nil {
+			return tally, err
+		}
+	}
+	return tally, nil
+}
+
+// replayRange processes each partition of the topic from the offset, or
+// from the first event at or after since, up to its current end.
+func replayRange(c *consumer, brokers []string, topic string, offset int64, since time.Time) (replayTally, error) {
+	tally := replayTally{}
+	client, err := sarama.NewClient(brokers, sarama.NewConfig())
+	if err != nil {
+		return tally, err
+	}
+	defer client.Close()
+	cons, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return tally, err
+	}
+	defer cons.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return tally, err
+	}
+	for _, p := range partitions {
+		oldest, err1 := client.GetOffset(topic, p, sarama.OffsetOldest)
+		end, err2 := client.GetOffset(topic, p, sarama.OffsetNewest)
+		if err := errors.Join(err1, err2); err != nil {
+			return tally, err
+		}
+		start := max(offset, oldest)
+		if !since.IsZero() {
+			// The first offset with a timestamp at or after since, or -1
+			// if there is none
+			at, err := client.GetOffset(topic, p, since.UnixMilli())
+			if err != nil {
+				return tally, err
+			}
+			if at < 0 {
+				at = end
+			}
+			start = max(start, at)
+		}
+		if start >= end {
+			continue
+		}
+		log.Printf("Replaying %s/%d from %d to %d", topic, p, start, end)
+
+		if err := replayPartitionRange(c, cons, topic, p, start, end, tally); err != nil {
+			return tally, err
+		}
+	}
+	return tally, nil
+}
+
+func replayPartitionRange(c *consumer, cons sarama.Consumer, topic string, partition int32, start, end int64, tally replayTally) error {
+	pc, err := cons.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	for msg := range pc.Messages() {
+		res, err := c.processMessage(msg)
+		if err != nil {
+			return fmt.Errorf("%s/%d@%d: %w", topic, partition, msg.Offset, err)
+		}
+		tally[res]++
+		if msg.Offset+1 >= end {
+			break
+		}
+	}
+	return nil
+}
+
+// diffRollups writes a line for every daily bucket, duration bucket and
+// win count that differs between the stores, as current -> replayed, and
+// returns how many there were.
+func diffRollups(w io.Writer, current, replayed db.RollupStore) (int, error) {
+	n := 0
+	diff := func(what string, cur, next int64) {
+		if cur != next {
+			fmt.Fprintf(w, "%s: %d -> %d\n", what, cur, next)
+			n++
+		}
+	}
+
+	curGames, err1 := current.GameBuckets(db.RollupDay, allTime[0], allTime[1])
+	newGames, err2 := replayed.GameBuckets(db.RollupDay, allTime[0], allTime[1])
+	curHist, err3 := current.DurationHistogram(allTime[0], allTime[1])
+	newHist, err4 := replayed.DurationHistogram(allTime[0], allTime[1])
+	curWins, err5 := current.TopWinners(1 << 20)
+	newWins, err6 := replayed.TopWinners(1 << 20)
+	if err := errors.Join(err1, err2, err3, err4, err5, err6); err != nil {
+		return 0, err
+	}
+
+	type gameKey struct {
+		day  string
+		mode string
+	}
+	games := make(map[gameKey][2]db.GameBucket)
+	for i, list := range [][]db.GameBucket{curGames, newGames} {
+		for _, b := range list {
+			k := gameKey{b.Bucket.Format(time.DateOnly), b.Mode}
+			pair := games[k]
+			pair[i] = b
+			games[k] = pair
+		}
+	}
+	keys := make([]gameKey, 0, len(games))
+	for k := range games {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].day != keys[j].day {
+			return keys[i].day < keys[j].day
+		}
+		return keys[i].mode < keys[j].mode
+	})
+	for _, k := range keys {
+		a, b := games[k][0], games[k][1]
+		name := fmt.Sprintf("games %s %s", k.day, k.mode)
+		diff(name+" started", int64(a.Started), int64(b.Started))
+		diff(name+" finished", int64(a.Finished), int64(b.Finished))
+		diff(name+" draws", int64(a.Draws), int64(b.Draws))
+		diff(name+" timed", int64(a.Timed), int64(b.Timed))
+		diff(name+" duration_ms", a.DurationMs, b.DurationMs)
+	}
+
+	hist := make(map[int64][2]int64)
+	for i, list := range [][]db.DurationBucket{curHist, newHist} {
+		for _, b := range list {
+			pair := hist[b.LeMs]
+			pair[i] = int64(b.Games)
+			hist[b.LeMs] = pair
+		}
+	}
+	bounds := make([]int64, 0, len(hist))
+	for le := range hist {
+		bounds = append(bounds, le)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	for _, le := range bounds {
+		diff(fmt.Sprintf("durations le_ms=%d", le), hist[le][0], hist[le][1])
+	}
+
+	wins := make(map[string][2]int64)
+	for i, list := range [][]db.WinCount{curWins, newWins} {
+		for _, wc := range list {
+			pair := wins[wc.Username]
+			pair[i] = int64(wc.Wins)
+			wins[wc.Username] = pair
+		}
+	}
+	names := make([]string, 0, len(wins))
+	for name := range wins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		diff("wins "+name, wins[name][0], wins[name][1])
+	}
+	return n, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fourinrow/db"
+)
+
+// statsMux serves the rollups:
+//
+//	GET /stats/games?period=hour|day&since=&until=  games per bucket and mode
+//	GET /stats/durations?since=&until=              duration mean and percentiles
+//	GET /stats/modes?since=&until=                  PvP vs PvE share
+//	GET /stats/wins?limit=                          most wins
+//	GET /stats/windows                              windowed metrics
+//
+// since/until take YYYY-MM-DD or RFC 3339. Hourly buckets default to the
+// last day, everything else to the last 30 days.
+func statsMux(store db.RollupStore, win *windows) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats/games", func(w http.ResponseWriter, r *http.Request) {
+		period := r.URL.Query().Get("period")
+		if period == "" {
+			period = db.RollupHour
+		}
+		if period != db.RollupHour && period != db.RollupDay {
+			http.Error(w, "period must be hour or day", http.StatusBadRequest)
+			return
+		}
+		since, until, ok := statsRange(w, r, period)
+		if !ok {
+			return
+		}
+		buckets, err := store.GameBuckets(period, since, until)
+		if err != nil {
+			statsError(w, err)
+			return
+		}
+		writeJSON(w, map[string]any{"period": period, "since": since, "until": until, "buckets": nonNil(buckets)})
+	})
+
+	mux.HandleFunc("GET /stats/durations", func(w http.ResponseWriter, r *http.Request) {
+		since, until, ok := statsRange(w, r, db.RollupDay)
+		if !ok {
+			return
+		}
+		hist, err1 := store.DurationHistogram(since, until)
+		days, err2 := store.GameBuckets(db.RollupDay, since, until)
+		if err := errors.Join(err1, err2); err != nil {
+			statsError(w, err)
+			return
+		}
+		writeJSON(w, durationStats(hist, days))
+	})
+
+	mux.HandleFunc("GET /stats/modes", func(w http.ResponseWriter, r *http.Request) {
+		since, until, ok := statsRange(w, r, db.RollupDay)
+		if !ok {
+			return
+		}
+		days, err := store.GameBuckets(db.RollupDay, since, until)
+		if err != nil {
+			statsError(w, err)
+			return
+		}
+		writeJSON(w, modeShares(days))
+	})
+
+	mux.HandleFunc("GET /stats/wins", func(w http.ResponseWriter, r *http.Request) {
+		limit := 10
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > 100 {
+				http.Error(w, "limit must be 1-100", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		wins, err := store.TopWinners(limit)
+		if err != nil {
+			statsError(w, err)
+			return
+		}
+		writeJSON(w, nonNil(wins))
+	})
+
+	mux.HandleFunc("GET /stats/windows", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, win.report())
+	})
+	return mux
+}
+
+// statsRange reads since/until, writing a 400 on a bad value.
+func statsRange(w http.ResponseWriter, r *http.Request, period string) (since, until time.Time, ok bool) {
+	q := r.URL.Query()
+	since, err1 := parseDay(q.Get("since"))
+	until, err2 := parseDay(q.Get("until"))
+	if err := errors.Join(err1, err2); err != nil {
+		http.Error(w, "since/until must be YYYY-MM-DD or RFC 3339", http.StatusBadRequest)
+		return since, until, false
+	}
+	if until.IsZero() {
+		until = time.Now().UTC()
+	}
+	if since.IsZero() {
+		since = until.AddDate(0, 0, -30)
+		if period == db.RollupHour {
+			since = until.Add(-24 * time.Hour)
+		}
+	}
+	return since, until, true
+}
+
+func parseDay(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+type DurationStats struct {
+	Games  int                 `json:"games"`
+	MeanMs int64               `json:"mean_ms"`
+	P50Ms  int64               `json:"p50_ms"`
+	P90Ms  int64               `json:"p90_ms"`
+	P99Ms  int64               `json:"p99_ms"`
+	Hist   []db.DurationBucket `json:"histogram"`
+}
+
+// durationStats works out the percentiles from the histogram, so each is the
+// upper bound of the bucket it falls in. Games longer than the last bound
+// report that bound.
+func durationStats(hist []db.DurationBucket, days []db.GameBucket) DurationStats {
+	st := DurationStats{Hist: nonNil(hist)}
+	var timed int
+	var total int64
+	for _, d := range days {
+		timed += d.Timed
+		total += d.DurationMs
+	}
+	if timed > 0 {
+		st.MeanMs = total / int64(timed)
+	}
+	for _, b := range hist {
+		st.Games += b.Games
+	}
+
+	percentile := func(p float64) int64 {
+		rank := int(math.Ceil(p * float64(st.Games)))
+		seen := 0
+		for _, b := range hist {
+			seen += b.Games
+			if seen >= rank {
+				if b.LeMs == math.MaxInt64 {
+					return db.DurationBounds[len(db.DurationBounds)-1]
+				}
+				return b.LeMs
+			}
+		}
+		return 0
+	}
+	if st.Games > 0 {
+		st.P50Ms, st.P90Ms, st.P99Ms = percentile(0.5), percentile(0.9), percentile(0.99)
+	}
+	return st
+}
+
+type ModeShare struct {
+	Games int     `json:"games"`
+	Share float64 `json:"share"`
+}
+
+// modeShares splits the games started by mode. Old events without a mode
+// count as "unknown".
+func modeShares(days []db.GameBucket) map[string]ModeShare {
+	counts := make(map[string]int)
+	total := 0
+	for _, d := range days {
+		mode := d.Mode
+		if mode == "" {
+			mode = "unknown"
+		}
+		counts[mode] += d.Started
+		total += d.Started
+	}
+	res := make(map[string]ModeShare, len(counts))
+	if total == 0 {
+		return res
+	}
+	for mode, n := range counts {
+		res[mode] = ModeShare{Games: n, Share: float64(n) / float64(total)}
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func statsError(w http.ResponseWriter, err error) {
+	log.Printf("[DB ERROR] Stats query failed: %v", err)
+	http.Error(w, "Failed to load stats", http.StatusInternalServerError)
+}
+
+// nonNil makes empty results encode as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"fourinrow/events"
+)
+
+// windowConfig shapes the windowed metrics. Tumbling and Sliding must be
+// multiples of Step.
+type windowConfig struct {
+	Step     time.Duration // pane size; the sliding window moves by this much
+	Tumbling time.Duration
+	Sliding  time.Duration
+	Lateness time.Duration // how far behind the newest event the watermark trails
+	Idle     time.Duration // a partition this quiet stops holding the watermark back
+	History  int           // closed tumbling windows kept
+}
+
+func addWindowFlags(fs *flag.FlagSet) *windowConfig {
+	cfg := &windowConfig{}
+	fs.DurationVar(&cfg.Step, "window-step", envDuration("WINDOW_STEP", time.Minute), "pane size, and how often the sliding window moves (WINDOW_STEP)")
+	fs.DurationVar(&cfg.Tumbling, "window-tumbling", envDuration("WINDOW_TUMBLING", 5*time.Minute), "tumbling window size (WINDOW_TUMBLING)")
+	fs.DurationVar(&cfg.Sliding, "window-sliding", envDuration("WINDOW_SLIDING", 15*time.Minute), "sliding window size (WINDOW_SLIDING)")
+	fs.DurationVar(&cfg.Lateness, "window-lateness", envDuration("WINDOW_LATENESS", 30*time.Second), "how late an event may arrive before it is dropped (WINDOW_LATENESS)")
+	fs.DurationVar(&cfg.Idle, "window-idle", envDuration("WINDOW_IDLE", time.Minute), "how long a partition may go without events before the watermark moves on without it (WINDOW_IDLE)")
+	fs.IntVar(&cfg.History, "window-history", 288, "closed tumbling windows to keep")
+	return cfg
+}
+
+func (c windowConfig) validate() error {
+	if c.Step < time.Second || c.Step%time.Second != 0 {
+		return fmt.Errorf("window step %s must be whole seconds", c.Step)
+	}
+	if c.Tumbling <= 0 || c.Tumbling%c.Step != 0 || c.Sliding <= 0 || c.Sliding%c.Step != 0 {
+		return fmt.Errorf("tumbling (%s) and sliding (%s) windows must be multiples of the step (%s)", c.Tumbling, c.Sliding, c.Step)
+	}
+	if c.Lateness < 0 || c.Idle < 0 {
+		return fmt.Errorf("negative lateness %s or idle timeout %s", c.Lateness, c.Idle)
+	}
+	return nil
+}
+
+// maxGameAge is how long a game may stay open in event time before it is
+// assumed lost (its game_finished never came) and stops counting as live.
+const maxGameAge = 6 * time.Hour
+
+// windows aggregates the event stream into tumbling and sliding windows on
+// event time. Events are bucketed into panes of Step; a window sums its
+// panes. The watermark trails the newest event by Lateness: events older
+// than it are dropped as late, and tumbling windows that end before it are
+// closed and kept in the history.
+//
+// Partitions are consumed concurrently and may be far apart after a restart
+// or a rebalance, so the watermark follows the partition that is furthest
+// behind. An assigned partition holds it back until its first event arrives,
+// and one that has been quiet for Idle stops holding it back.
+type windows struct {
+	mu    sync.Mutex
+	cfg   windowConfig
+	step  int64 // the config in Unix seconds
+	tumb  int64
+	slide int64
+	late  int64
+
+	panes map[int64]*pane // by start
+	games map[string]*liveGame
+	parts map[int32]*partitionClock
+
+	newest    int64 // newest event time seen; 0 before the first event
+	oldest    int64 // oldest event time seen before the watermark was known
+	wm        int64 // only moves forward
+	started   bool  // the watermark is known
+	flushed   bool
+	lateCount int
+	base      int   // games live at baseEnd; earlier panes are folded in
+	baseEnd   int64 // start of the oldest pane kept
+	nextTumb  int64 // start of the next tumbling window to close
+	closed    []WindowMetrics
+}
+
+// pane holds what happened in one step of event time.
+type pane struct {
+	started, finished     int
+	finishedDisconnected  int // finished games in which someone disconnected
+	moves                 int
+	matched, botFallbacks int
+	waits                 []int64 // matchmaking waits, ms
+	delta                 int     // change in live games
+}
+
+type liveGame struct {
+	start        int64
+	disconnected bool
+}
+
+type partitionClock struct {
+	newest int64     // 0 until the partition's first event
+	heard  time.Time // wall time of its last event, or of its assignment
+}
+
+func newWindows(cfg windowConfig) *windows {
+	return &windows{
+		cfg:   cfg,
+		step:  int64(cfg.Step / time.Second),
+		tumb:  int64(cfg.Tumbling / time.Second),
+		slide: int64(cfg.Sliding / time.Second),
+		late:  int64(cfg.Lateness / time.Second),
+		panes: make(map[int64]*pane),
+		games: make(map[string]*liveGame),
+		parts: make(map[int32]*partitionClock),
+	}
+}
+
+func floorTo(t, size int64) int64 {
+	return t - ((t%size)+size)%size
+}
+
+// assign replaces the partitions this consumer reads, after a rebalance.
+// New ones hold the watermark back until they deliver or go idle.
+func (w *windows) assign(partitions []int32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	parts := make(map[int32]*partitionClock, len(partitions))
+	for _, p := range partitions {
+		if pc := w.parts[p]; pc != nil {
+			parts[p] = pc
+		} else {
+			parts[p] = &partitionClock{heard: time.Now()}
+		}
+	}
+	w.parts = parts
+}
+
+// candidate is where the partitions would put the watermark, or false while
+// a live partition has not delivered anything yet.
+func (w *windows) candidate() (int64, bool) {
+	now := time.Now()
+	slowest, live := w.newest, false
+	for _, pc := range w.parts {
+		if now.Sub(pc.heard) > w.cfg.Idle {
+			continue
+		}
+		if pc.newest == 0 {
+			return 0, false
+		}
+		if !live || pc.newest < slowest {
+			slowest, live = pc.newest, true
+		}
+	}
+	return slowest - w.late, true
+}
+
+// start fixes the bookkeeping once the watermark is first known, keeping
+// every pane filled so far.
+func (w *windows) start(wm int64) {
+	from := min(w.oldest, wm)
+	w.baseEnd = floorTo(from, w.step)
+	w.nextTumb = floorTo(from, w.tumb)
+	w.wm = wm
+	w.started = true
+}
+
+func (w *windows) pane(t int64) *pane {
+	start := floorTo(t, w.step)
+	p := w.panes[start]
+	if p == nil {
+		p = &pane{}
+		w.panes[start] = p
+	}
+	return p
+}
+
+// add feeds in one event read from a partition.
+func (w *windows) add(partition int32, e events.Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t := e.Timestamp
+	if w.flushed || (w.started && (t < w.wm || t < w.baseEnd)) {
+		w.lateCount++
+		return
+	}
+	pc := w.parts[partition]
+	if pc == nil {
+		pc = &partitionClock{}
+		w.parts[partition] = pc
+	}
+	pc.newest, pc.heard = max(pc.newest, t), time.Now()
+	if w.newest == 0 || (!w.started && t < w.oldest) {
+		w.oldest = t
+	}
+	w.newest = max(w.newest, t)
+
+	switch p := e.Payload.(type) {
+	case events.GameStartedPayload:
+		pn := w.pane(t)
+		pn.started++
+		if _, ok := w.games[e.GameID]; !ok {
+			w.games[e.GameID] = &liveGame{start: t}
+			pn.delta++
+		}
+
+	case events.GameFinishedPayload:
+		pn := w.pane(t)
+		pn.finished++
+		pn.moves += p.Moves
+		if g, ok := w.games[e.GameID]; ok {
+			delete(w.games, e.GameID)
+			pn.delta--
+			if g.disconnected {
+				pn.finishedDisconnected++
+			}
+		}
+
+	case events.PlayerDisconnectedPayload:
+		if g, ok := w.games[e.GameID]; ok {
+			g.disconnected = true
+		}
+
+	case events.MatchmakingMatchedPayload:
+		pn := w.pane(t)
+		pn.matched++
+		pn.waits = append(pn.waits, p.WaitMs)
+
+	case events.MatchmakingTimeoutBotPayload:
+		pn := w.pane(t)
+		pn.botFallbacks++
+		pn.waits = append(pn.waits, p.WaitMs)
+	}
+	w.advance()
+}
+
+// flush closes every window holding events, for a recorded stream that has
+// ended. Later events count as late.
+func (w *windows) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.newest == 0 {
+		return
+	}
+	w.newest = floorTo(w.newest, w.tumb) + w.tumb + w.late
+	w.flushed = true
+	if !w.started {
+		w.start(w.newest - w.late)
+	}
+	w.wm = w.newest - w.late
+	w.advance()
+}
+
+// advance closes the tumbling windows the watermark has passed and folds
+// panes no window needs any more into base.
+func (w *windows) advance() {
+	if wm, ok := w.candidate(); ok && !w.flushed {
+		if !w.started {
+			w.start(wm)
+		}
+		w.wm = max(w.wm, wm)
+	}
+	if !w.started {
+		return
+	}
+	wm := w.wm
+	for w.nextTumb+w.tumb <= wm {
+		w.closed = append(w.closed, w.metrics(w.nextTumb, w.nextTumb+w.tumb))
+		w.nextTumb += w.tumb
+	}
+	if n := len(w.closed) - w.cfg.History; n > 0 {
+		w.closed = append([]WindowMetrics(nil), w.closed[n:]...)
+	}
+
+	for id, g := range w.games {
+		if g.start < wm-int64(maxGameAge/time.Second) {
+			delete(w.games, id)
+			w.pane(wm).delta--
+		}
+	}
+
+	keep := min(w.nextTumb, floorTo(wm, w.step)-w.slide)
+	for ; w.baseEnd < keep; w.baseEnd += w.step {
+		if p, ok := w.panes[w.baseEnd]; ok {
+			w.base += p.delta
+			delete(w.panes, w.baseEnd)
+		}
+	}
+}
+
+// WindowMetrics are the aggregates of one window. Rates and averages are
+// null when the window has nothing to divide by.
+type WindowMetrics struct {
+	Start               time.Time `json:"start"`
+	End                 time.Time `json:"end"`
+	GamesStarted        int       `json:"games_started"`
+	GamesFinished       int       `json:"games_finished"`
+	ConcurrentGames     int       `json:"concurrent_games"`      // live at the end
+	PeakConcurrentGames int       `json:"peak_concurrent_games"` // at a pane boundary
+	MatchmakingWaits    int       `json:"matchmaking_waits"`
+	WaitP50Ms           *int64    `json:"matchmaking_wait_p50_ms"`
+	WaitP95Ms           *int64    `json:"matchmaking_wait_p95_ms"`
+	BotFallbackRate     *float64  `json:"bot_fallback_rate"` // of players leaving the queue
+	DisconnectRate      *float64  `json:"disconnect_rate"`   // of finished games
+	AvgMovesPerGame     *float64  `json:"avg_moves_per_game"`
+}
+
+// metrics sums the panes in [from, to). The caller holds w.mu.
+func (w *windows) metrics(from, to int64) WindowMetrics {
+	m := WindowMetrics{Start: time.Unix(from, 0).UTC(), End: time.Unix(to, 0).UTC()}
+	live := w.base
+	for t := w.baseEnd; t < from; t += w.step {
+		if p, ok := w.panes[t]; ok {
+			live += p.delta
+		}
+	}
+
+	m.PeakConcurrentGames = live
+
+	var waits []int64
+	var moves, finishedDisconnected, matched, bots int
+	for t := from; t < to; t += w.step {
+		if p, ok := w.panes[t]; ok {
+			m.GamesStarted += p.started
+			m.GamesFinished += p.finished
+			finishedDisconnected += p.finishedDisconnected
+			moves += p.moves
+			matched += p.matched
+			bots += p.botFallbacks
+			waits = append(waits, p.waits...)
+			live += p.delta
+		}
+		m.PeakConcurrentGames = max(m.PeakConcurrentGames, live)
+	}
+	m.ConcurrentGames = live
+
+	m.MatchmakingWaits = len(waits)
+	if len(waits) > 0 {
+		sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+		m.WaitP50Ms, m.WaitP95Ms = percentileOf(waits, 0.5), percentileOf(waits, 0.95)
+	}
+	m.BotFallbackRate = ratio(bots, matched+bots)
+	m.DisconnectRate = ratio(finishedDisconnected, m.GamesFinished)
+	m.AvgMovesPerGame = ratio(moves, m.GamesFinished)
+	return m
+}
+
+// percentileOf takes the nearest-rank percentile of sorted values.
+func percentileOf(sorted []int64, p float64) *int64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	v := sorted[max(i, 0)]
+	return &v
+}
+
+func ratio(a, b int) *float64 {
+	if b == 0 {
+		return nil
+	}
+	r := float64(a) / float64(b)
+	return &r
+}
+
+type WindowsReport struct {
+	Watermark  time.Time       `json:"watermark"`
+	Newest     time.Time       `json:"newest_event"`
+	LateEvents int             `json:"late_events"`
+	LiveGames  int             `json:"live_games"`
+	Sliding    *WindowMetrics  `json:"sliding"`  // the last Sliding before the watermark
+	Tumbling   []WindowMetrics `json:"tumbling"` // closed windows, oldest first
+}
+
+func (w *windows) report() WindowsReport {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r := WindowsReport{LateEvents: w.lateCount, Tumbling: append([]WindowMetrics{}, w.closed...)}
+	if !w.started {
+		return r
+	}
+	r.Watermark = time.Unix(w.wm, 0).UTC()
+	r.Newest = time.Unix(w.newest, 0).UTC()
+	r.LiveGames = w.base
+	for _, p := range w.panes {
+		r.LiveGames += p.delta
+	}
+	end := floorTo(w.wm, w.step)
+	sliding := w.metrics(end-w.slide, end)
+	r.Sliding = &sliding
+	return r
+}
+
+// windowsFromFiles runs `consumer windows FILE...`: it feeds recorded
+// JSON-lines event files (such as the file sink writes) through the windows
+// in order, closes them all and prints the report. Invalid and duplicate
+// events are skipped, so the output is repeatable for a given recording.
+// With -golden it compares the report with a saved one instead, for checking
+// a change against events/fixtures/streams.
+func windowsFromFiles(args []string) {
+	fs := flag.NewFlagSet("windows", flag.ExitOnError)
+	cfg := addWindowFlags(fs)
+	golden := fs.String("golden", "", "compare the report with this file and fail on a difference")
+	update := fs.Bool("update", false, "rewrite the -golden file")
+	fs.Parse(args)
+	if err := cfg.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if fs.NArg() == 0 {
+		log.Fatal("usage: consumer windows [flags] FILE...")
+	}
+
+	out, err := windowsReport(*cfg, fs.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case *golden == "":
+		os.Stdout.Write(out)
+	case *update:
+		if err := os.WriteFile(*golden, out, 0o644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("UPDATED %s", *golden)
+	default:
+		want, err := os.ReadFile(*golden)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !bytes.Equal(want, out) {
+			log.Printf("FAIL report differs from %s, got:\n%s", *golden, out)
+			os.Exit(1)
+		}
+		fmt.Printf("ok: report matches %s\n", *golden)
+	}
+}
+
+// windowsReport runs the event files through the windows in order, skipping
+// redelivered events, closes every window and returns the indented report.
+func windowsReport(cfg windowConfig, paths []string) ([]byte, error) {
+	w := newWindows(cfg)
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		err := readEventFile(path, func(e events.Event) {
+			if !seen[e.ID] {
+				seen[e.ID] = true
+				w.add(0, e)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.flush()
+
+	out, err := json.MarshalIndent(w.report(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// readEventFile decodes a JSON-lines event file, logging lines that aren't
+// valid events.
+func readEventFile(path string, fn func(events.Event)) error {
+	return scanLines(path, func(line int, data []byte) error {
+		e, err := events.Decode(data)
+		if err != nil {
+			log.Printf("%s:%d: %v", path, line, err)
+			return nil
+		}
+		fn(e)
+		return nil
+	})
+}
+
+// scanLines calls fn with each non-blank line of a JSON-lines file, and
+// stops at the first error. The data is only valid during the call.
+func scanLines(path string, fn func(line int, data []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		if err := fn(line, sc.Bytes()); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
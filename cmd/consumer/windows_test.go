@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the windows golden file")
+
+// TestWindowsGolden runs the recorded sample stream through the windows
+// with the default settings and compares the report with the saved one.
+func TestWindowsGolden(t *testing.T) {
+	const (
+		stream = "../../events/fixtures/streams/sample.jsonl"
+		golden = "../../events/fixtures/streams/sample.windows.json"
+	)
+	cfg := windowConfig{Step: time.Minute, Tumbling: 5 * time.Minute, Sliding: 15 * time.Minute, Lateness: 30 * time.Second, Idle: time.Minute, History: 288}
+	got, err := windowsReport(cfg, []string{stream})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("updated %s", golden)
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("report differs from %s, got:\n%s", golden, got)
+	}
+}
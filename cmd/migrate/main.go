@@ -0,0 +1,116 @@
+// Command migrate manages the database schema.
+//
+//	migrate up [version]   apply pending migrations (up to version, default latest)
+//	migrate down [steps]   roll back the last N migrations (default 1)
+//	migrate status         list migrations and when they were applied
+//	migrate version        print the current schema version
+//
+// It works on Postgres (default) and SQLite (-driver sqlite -database file.db).
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"fourinrow/db"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	url := flag.String("database", os.Getenv("DATABASE_URL"), "connection URL or SQLite file (defaults to $DATABASE_URL)")
+	driver := flag.String("driver", envOr("DB_DRIVER", db.DialectPostgres), "postgres or sqlite (defaults to $DB_DRIVER)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: migrate [-database url] up [version] | down [steps] | status | version")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *url == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := sql.Open(*driver, *url)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	defer conn.Close()
+
+	m, err := db.NewMigrator(conn, *driver)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	arg := func(def int) int {
+		if flag.NArg() < 2 {
+			return def
+		}
+		n, err := strconv.Atoi(flag.Arg(1))
+		if err != nil {
+			log.Fatalf("Invalid number %q", flag.Arg(1))
+		}
+		return n
+	}
+
+	switch flag.Arg(0) {
+	case "up":
+		applied, err := m.Up(ctx, arg(0))
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		for _, v := range applied {
+			fmt.Printf("Applied %04d\n", v)
+		}
+
+	case "down":
+		reverted, err := m.Down(ctx, arg(1))
+		if err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		for _, v := range reverted {
+			fmt.Printf("Reverted %04d\n", v)
+		}
+
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read status: %v", err)
+		}
+		for _, st := range status {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", st.Version, st.Name, applied)
+		}
+
+	case "version":
+		v, err := m.Version(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read version: %v", err)
+		}
+		fmt.Printf("database %d, binary %d\n", v, m.Latest())
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,86 @@
+package db
+
+import "time"
+
+// HeadToHead is the record between two players, from PlayerA's side.
+type HeadToHead struct {
+	PlayerA       string          `json:"playerA"`
+	PlayerB       string          `json:"playerB"`
+	Games         int             `json:"games"`
+	WinsA         int             `json:"winsA"`
+	WinsB         int             `json:"winsB"`
+	Draws         int             `json:"draws"`
+	LongestStreak Streak          `json:"longestStreak"`
+	CurrentStreak Streak          `json:"currentStreak"`
+	Recent        []RivalryResult `json:"recent"` // newest first
+}
+
+// Streak is a run of consecutive wins by one player. Username is empty when
+// nobody has won yet.
+type Streak struct {
+	Username string `json:"username"`
+	Length   int    `json:"length"`
+}
+
+type RivalryResult struct {
+	GameID     string    `json:"gameId"`
+	Winner     string    `json:"winner"` // username or "draw"
+	First      string    `json:"first"`  // who moved first
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// HeadToHeadSummary is the short form sent in the "start" message.
+type HeadToHeadSummary struct {
+	Games  int    `json:"games"`
+	Wins   int    `json:"wins"`
+	Losses int    `json:"losses"`
+	Draws  int    `json:"draws"`
+	Streak Streak `json:"streak"`
+}
+
+// For returns the record from one player's point of view.
+func (h *HeadToHead) For(username string) HeadToHeadSummary {
+	s := HeadToHeadSummary{Games: h.Games, Draws: h.Draws, Wins: h.WinsA, Losses: h.WinsB, Streak: h.CurrentStreak}
+	if username == h.PlayerB {
+		s.Wins, s.Losses = h.WinsB, h.WinsA
+	}
+	return s
+}
+
+// headToHead builds the record from every game between a and b, newest first.
+func headToHead(a, b string, games []GameRecord, recent int) *HeadToHead {
+	h := &HeadToHead{PlayerA: a, PlayerB: b, Games: len(games), Recent: []RivalryResult{}}
+
+	var run Streak
+	for i := len(games) - 1; i >= 0; i-- {
+		g := games[i]
+		switch g.Winner {
+		case a:
+			h.WinsA++
+		case b:
+			h.WinsB++
+		default:
+			h.Draws++
+			run = Streak{}
+			continue
+		}
+
+		if run.Username == g.Winner {
+			run.Length++
+		} else {
+			run = Streak{Username: g.Winner, Length: 1}
+		}
+		if run.Length > h.LongestStreak.Length {
+			h.LongestStreak = run
+		}
+	}
+	h.CurrentStreak = run
+
+	for i, g := range games {
+		if i == recent {
+			break
+		}
+		h.Recent = append(h.Recent, RivalryResult{GameID: g.ID, Winner: g.Winner, First: g.Player1, FinishedAt: g.FinishedAt})
+	}
+	return h
+}
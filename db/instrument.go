@@ -0,0 +1,75 @@
+package db
+
+import (
+	"time"
+
+	"fourinrow/game"
+	"fourinrow/metrics"
+)
+
+var (
+	saveSeconds = metrics.NewHistogramVec("fourinrow_db_save_seconds",
+		"Time taken by database writes, by operation.", metrics.DefBuckets, "op")
+	saveErrors = metrics.NewCounterVec("fourinrow_db_save_errors_total",
+		"Failed database writes, by operation.", "op")
+)
+
+// instrumented times a Store's writes. Reads pass straight through.
+type instrumented struct {
+	Store
+}
+
+// Instrument wraps a store so its writes are recorded in the metrics.
+// InitDB applies it to Repo.
+func Instrument(s Store) Store {
+	return instrumented{s}
+}
+
+func observeSave(op string, start time.Time, err error) {
+	saveSeconds.With(op).ObserveSince(start)
+	if err != nil {
+		saveErrors.With(op).Inc()
+	}
+}
+
+func (s instrumented) SaveGame(g *game.Game, events ...OutboxEvent) error {
+	start := time.Now()
+	err := s.Store.SaveGame(g, events...)
+	observeSave("save_game", start, err)
+	return err
+}
+
+func (s instrumented) ImportGame(g *game.Game) error {
+	start := time.Now()
+	err := s.Store.ImportGame(g)
+	observeSave("import_game", start, err)
+	return err
+}
+
+func (s instrumented) SaveLiveGame(snap *game.Snapshot) error {
+	start := time.Now()
+	err := s.Store.SaveLiveGame(snap)
+	observeSave("save_live_game", start, err)
+	return err
+}
+
+func (s instrumented) DeleteLiveGame(gameID string) error {
+	start := time.Now()
+	err := s.Store.DeleteLiveGame(gameID)
+	observeSave("delete_live_game", start, err)
+	return err
+}
+
+func (s instrumented) AddOutbox(events ...OutboxEvent) error {
+	start := time.Now()
+	err := s.Store.AddOutbox(events...)
+	observeSave("add_outbox", start, err)
+	return err
+}
+
+func (s instrumented) SaveReport(rep *game.Report) error {
+	start := time.Now()
+	err := s.Store.SaveReport(rep)
+	observeSave("save_report", start, err)
+	return err
+}
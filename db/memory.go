@@ -0,0 +1,314 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"fourinrow/game"
+)
+
+// MemoryStore keeps everything in process memory. It is the default when no
+// database is configured and is handy in tests.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	games   map[string]GameRecord
+	moves   map[string][]MoveRecord
+	players map[string]PlayerRecord
+	history map[string][]RatingPoint
+	reports map[string]*game.Report
+	live    map[string]*game.Snapshot
+	outbox  memoryOutbox
+	rollups memoryRollups
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		games:   make(map[string]GameRecord),
+		moves:   make(map[string][]MoveRecord),
+		players: make(map[string]PlayerRecord),
+		history: make(map[string][]RatingPoint),
+		reports: make(map[string]*game.Report),
+		live:    make(map[string]*game.Snapshot),
+	}
+}
+
+func (m *MemoryStore) Close() error { return nil }
+
+func (m *MemoryStore) SaveGame(g *game.Game, events ...OutboxEvent) error {
+	rec, p1, p2 := gameRecord(g, time.Now())
+	if rec.Winner == "" {
+		return m.AddOutbox(events...)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outbox.add(events)
+
+	old, exists := m.games[g.ID]
+	if exists {
+		// Same columns as the SQL upsert: players and flags are fixed at insert
+		old.Winner, old.FinishedAt = rec.Winner, rec.FinishedAt
+		old.FinishReason, old.MoveCount, old.DurationMs = rec.FinishReason, rec.MoveCount, rec.DurationMs
+		rec = old
+	}
+	m.games[g.ID] = rec
+
+	for _, p := range []*game.Player{p1, p2} {
+		if p == nil {
+			continue
+		}
+		pr, ok := m.players[p.Username]
+		if !ok {
+			pr = PlayerRecord{Username: p.Username, Rating: game.DefaultRating, FirstSeenAt: rec.FinishedAt}
+		}
+		pr.IsBot, pr.LastSeenAt = p.IsBot, rec.FinishedAt
+		m.players[p.Username] = pr
+	}
+
+	if !exists && p1 != nil && p2 != nil {
+		m.recordResult(rec)
+	}
+
+	m.moves[g.ID] = moveRecords(g)
+	return nil
+}
+
+func (m *MemoryStore) ImportGame(g *game.Game) error {
+	rec, _, _ := gameRecord(g, time.Now())
+	if rec.Winner == "" {
+		return errors.New("game has no result")
+	}
+	rec.Rated, rec.Imported = false, true
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.games[g.ID]; exists {
+		return nil
+	}
+	m.games[g.ID] = rec
+	m.moves[g.ID] = moveRecords(g)
+	return nil
+}
+
+// recordResult updates streaks and ratings; the caller holds the lock.
+func (m *MemoryStore) recordResult(rec GameRecord) {
+	state := func(name string) *playerState {
+		p := m.players[name]
+		return &playerState{Username: name, IsBot: p.IsBot, Rating: p.Rating, CurrentStreak: p.CurrentStreak, BestStreak: p.BestStreak}
+	}
+	p1, p2 := state(rec.Player1), state(rec.Player2)
+
+	for _, h := range applyResult(p1, p2, rec.Winner, rec.Rated, rec.ID, rec.FinishedAt) {
+		m.history[h.Username] = append(m.history[h.Username], h.RatingPoint)
+	}
+	for _, st := range []*playerState{p1, p2} {
+		p := m.players[st.Username]
+		p.Rating, p.CurrentStreak, p.BestStreak = st.Rating, st.CurrentStreak, st.BestStreak
+		m.players[st.Username] = p
+	}
+}
+
+func (m *MemoryStore) GetGame(id string) (*GameRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if g, ok := m.games[id]; ok {
+		return &g, nil
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) ListGames(f GameFilter) ([]GameRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var res []GameRecord
+	for _, g := range m.games {
+		if f.Player != "" && g.Player1 != f.Player && g.Player2 != f.Player {
+			continue
+		}
+		if f.Opponent != "" && g.Player1 != f.Opponent && g.Player2 != f.Opponent {
+			continue
+		}
+		if !f.Since.IsZero() && g.FinishedAt.Before(f.Since) {
+			continue
+		}
+		if !f.Until.IsZero() && !g.FinishedAt.Before(f.Until) {
+			continue
+		}
+		if f.Played && g.Imported {
+			continue
+		}
+		res = append(res, g)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].FinishedAt.After(res[j].FinishedAt) })
+	if f.Limit > 0 && len(res) > f.Limit {
+		res = res[:f.Limit]
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) GetMoves(gameID string) ([]MoveRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]MoveRecord(nil), m.moves[gameID]...), nil
+}
+
+func (m *MemoryStore) GetPlayer(name string) (*PlayerRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if p, ok := m.players[name]; ok {
+		return &p, nil
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) GetPlayerProfile(name string, recentGames int) (*PlayerProfile, error) {
+	p, _ := m.GetPlayer(name)
+	if p == nil {
+		return nil, nil
+	}
+
+	m.mu.RLock()
+	byKey := make(map[[2]int]*statBucket)
+	for _, g := range m.games {
+		color, oppBot := 0, false
+		if g.Imported {
+			continue
+		}
+		switch name {
+		case g.Player1:
+			color, oppBot = 1, g.Player2IsBot
+		case g.Player2:
+			color, oppBot = 2, g.Player1IsBot
+		default:
+			continue
+		}
+
+		key := [2]int{color, 0}
+		if oppBot {
+			key[1] = 1
+		}
+		b := byKey[key]
+		if b == nil {
+			b = &statBucket{Color: color, OppBot: oppBot}
+			byKey[key] = b
+		}
+		b.Games++
+		switch g.Winner {
+		case name:
+			b.Wins++
+		case "draw":
+			b.Draws++
+		}
+		b.Moves += int64(g.MoveCount)
+		b.DurationMs += g.DurationMs
+	}
+
+	history := m.history[name]
+	if len(history) > 50 {
+		history = history[len(history)-50:]
+	}
+	history = append([]RatingPoint(nil), history...)
+	m.mu.RUnlock()
+
+	buckets := make([]statBucket, 0, len(byKey))
+	for _, b := range byKey {
+		buckets = append(buckets, *b)
+	}
+	// A zero Limit would list every game
+	var recent []GameRecord
+	if recentGames > 0 {
+		recent, _ = m.ListGames(GameFilter{Player: name, Limit: recentGames, Played: true})
+	}
+	return buildProfile(p, buckets, history, recent), nil
+}
+
+func (m *MemoryStore) GetHeadToHead(a, b string, recentGames int) (*HeadToHead, error) {
+	games, _ := m.ListGames(GameFilter{Player: a, Opponent: b, Played: true})
+	return headToHead(a, b, games, recentGames), nil
+}
+
+func (m *MemoryStore) GetHeadToHeadTotals(a, b string) (*HeadToHead, error) {
+	h, _ := m.GetHeadToHead(a, b, 0)
+	h.LongestStreak = Streak{}
+	return h, nil
+}
+
+func (m *MemoryStore) GetStandings(since time.Time) ([]LeaderboardEntry, error) {
+	m.mu.RLock()
+	byName := make(map[string]*LeaderboardEntry)
+	for _, g := range m.games {
+		if g.FinishedAt.Before(since) || g.Imported {
+			continue
+		}
+		for _, name := range []string{g.Player1, g.Player2} {
+			p, ok := m.players[name]
+			if !ok || p.IsBot {
+				continue
+			}
+			e := byName[name]
+			if e == nil {
+				e = &LeaderboardEntry{Username: name, Rating: p.Rating, BestStreak: p.BestStreak}
+				byName[name] = e
+			}
+			e.Games++
+			switch g.Winner {
+			case name:
+				e.TotalWins++
+			case "draw":
+				e.Draws++
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	res := make([]LeaderboardEntry, 0, len(byName))
+	for _, e := range byName {
+		res = append(res, *e)
+	}
+	if !since.IsZero() {
+		games, _ := m.ListGames(GameFilter{Since: since, Played: true})
+		res = withPeriodStreaks(res, games)
+	}
+	return finishStandings(res), nil
+}
+
+func (m *MemoryStore) SaveLiveGame(snap *game.Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.live[snap.ID] = snap
+	return nil
+}
+
+func (m *MemoryStore) DeleteLiveGame(gameID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.live, gameID)
+	return nil
+}
+
+func (m *MemoryStore) ListLiveGames() ([]*game.Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*game.Snapshot, 0, len(m.live))
+	for _, snap := range m.live {
+		res = append(res, snap)
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) SaveReport(rep *game.Report) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reports[rep.GameID] = rep
+	return nil
+}
+
+func (m *MemoryStore) GetReport(gameID string) (*game.Report, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.reports[gameID], nil
+}
@@ -0,0 +1,319 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in db/migrations/<dialect> as NNNN_name.up.sql /
+// NNNN_name.down.sql and are compiled into the binary. Every dialect must have
+// the same versions.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// Arbitrary key for pg_advisory_lock so only one instance migrates at a time.
+const migrationLockID = 0x46495221
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// LoadMigrations parses a dialect's embedded migration files, ordered by version.
+func LoadMigrations(dialect string) ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/"+dialect+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, f := range files {
+		base := path.Base(f)
+		name, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("bad migration file name %q", base)
+		}
+		num, label, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version in %q", base)
+		}
+
+		body, err := migrationFiles.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	if len(byVersion) == 0 {
+		return nil, fmt.Errorf("no migrations for dialect %q", dialect)
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d has no up file", m.Version)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, dialect string) (*Migrator, error) {
+	ms, err := LoadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: ms}, nil
+}
+
+// Latest is the newest version the migrator can apply.
+func (m *Migrator) Latest() int {
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// withLock runs fn on a single connection holding the migration lock. On
+// Postgres that is an advisory lock; on SQLite fn runs inside a BEGIN IMMEDIATE
+// transaction, which takes the write lock before schema_migrations is read so
+// a second process waits instead of applying the same migration again.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch m.dialect {
+	case DialectPostgres:
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	case DialectSQLite:
+		if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		// Commit even when fn fails: runMigration has already rolled back the
+		// failed script to its savepoint, the earlier ones stay applied.
+		defer func() {
+			if _, cerr := conn.ExecContext(context.Background(), `COMMIT`); cerr != nil {
+				conn.ExecContext(context.Background(), `ROLLBACK`)
+				if err == nil {
+					err = cerr
+				}
+			}
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func currentVersion(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}) (int, error) {
+	var v sql.NullInt64
+	err := q.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&v)
+	return int(v.Int64), err
+}
+
+// Version returns the schema version recorded in the database (0 if none).
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var v int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		v, err = currentVersion(ctx, conn)
+		return err
+	})
+	return v, err
+}
+
+// Up applies pending migrations up to `target` (0 means latest) and returns
+// the versions it applied. It refuses to touch a schema newer than the binary.
+func (m *Migrator) Up(ctx context.Context, target int) ([]int, error) {
+	if target <= 0 {
+		target = m.Latest()
+	}
+
+	var applied []int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		cur, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if cur > m.Latest() {
+			return fmt.Errorf("%w (database %d, binary %d)", ErrSchemaTooNew, cur, m.Latest())
+		}
+
+		for _, mig := range m.migrations {
+			if mig.Version <= cur || mig.Version > target {
+				continue
+			}
+			if err := m.runMigration(ctx, conn, mig.Up, func(tx execer) error {
+				_, err := tx.ExecContext(ctx,
+					`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+					mig.Version, mig.Name, time.Now())
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			applied = append(applied, mig.Version)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the last `steps` applied migrations and returns their versions.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]int, error) {
+	var reverted []int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			mig := m.migrations[i]
+
+			var exists bool
+			if err := conn.QueryRowContext(ctx,
+				`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, mig.Version,
+			).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("migration %04d_%s cannot be rolled back", mig.Version, mig.Name)
+			}
+
+			if err := m.runMigration(ctx, conn, mig.Down, func(tx execer) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("rollback %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			reverted = append(reverted, mig.Version)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists every known migration and when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var res []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied := make(map[int]time.Time)
+		rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v int
+			var at time.Time
+			if err := rows.Scan(&v, &at); err != nil {
+				return err
+			}
+			applied[v] = at
+		}
+
+		for _, mig := range m.migrations {
+			st := MigrationStatus{Version: mig.Version, Name: mig.Name}
+			if at, ok := applied[mig.Version]; ok {
+				st.AppliedAt = &at
+			}
+			res = append(res, st)
+		}
+		return rows.Err()
+	})
+	return res, err
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// runMigration executes one script and its bookkeeping atomically: in its own
+// transaction on Postgres, under a savepoint of withLock's transaction on SQLite.
+func (m *Migrator) runMigration(ctx context.Context, conn *sql.Conn, script string, record func(execer) error) error {
+	if m.dialect == DialectSQLite {
+		if _, err := conn.ExecContext(ctx, `SAVEPOINT migration`); err != nil {
+			return err
+		}
+		err := apply(ctx, conn, script, record)
+		if err != nil {
+			conn.ExecContext(context.Background(), `ROLLBACK TO migration`)
+		}
+		if _, rerr := conn.ExecContext(context.Background(), `RELEASE migration`); err == nil {
+			err = rerr
+		}
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := apply(ctx, tx, script, record); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func apply(ctx context.Context, q execer, script string, record func(execer) error) error {
+	if _, err := q.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	return record(q)
+}
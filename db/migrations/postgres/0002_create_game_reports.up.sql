@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS game_reports (
+	game_id TEXT PRIMARY KEY,
+	report JSONB NOT NULL,
+	created_at TIMESTAMP
+);
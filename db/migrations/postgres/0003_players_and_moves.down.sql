@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS games_finished_at_idx;
+DROP INDEX IF EXISTS games_player2_idx;
+DROP INDEX IF EXISTS games_player1_idx;
+DROP TABLE IF EXISTS moves;
+DROP TABLE IF EXISTS players;
@@ -0,0 +1,30 @@
+CREATE TABLE players (
+	username TEXT PRIMARY KEY,
+	is_bot BOOLEAN NOT NULL DEFAULT FALSE,
+	first_seen_at TIMESTAMP,
+	last_seen_at TIMESTAMP
+);
+
+INSERT INTO players (username, first_seen_at, last_seen_at)
+SELECT username, MIN(created_at), MAX(finished_at) FROM (
+	SELECT player1 AS username, created_at, finished_at FROM games
+	UNION ALL
+	SELECT player2, created_at, finished_at FROM games
+) p
+WHERE username IS NOT NULL AND username != ''
+GROUP BY username;
+
+CREATE TABLE moves (
+	game_id TEXT NOT NULL REFERENCES games (game_id) ON DELETE CASCADE,
+	ply INTEGER NOT NULL,
+	player TEXT NOT NULL,
+	color INTEGER NOT NULL,
+	col INTEGER NOT NULL,
+	row_index INTEGER NOT NULL,
+	played_at TIMESTAMP,
+	PRIMARY KEY (game_id, ply)
+);
+
+CREATE INDEX games_player1_idx ON games (player1);
+CREATE INDEX games_player2_idx ON games (player2);
+CREATE INDEX games_finished_at_idx ON games (finished_at);
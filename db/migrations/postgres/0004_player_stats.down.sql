@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS rating_history;
+
+ALTER TABLE players DROP COLUMN best_streak;
+ALTER TABLE players DROP COLUMN current_streak;
+ALTER TABLE players DROP COLUMN rating;
+
+ALTER TABLE games DROP COLUMN rated;
+ALTER TABLE games DROP COLUMN player2_is_bot;
+ALTER TABLE games DROP COLUMN player1_is_bot;
+ALTER TABLE games DROP COLUMN duration_ms;
+ALTER TABLE games DROP COLUMN move_count;
+ALTER TABLE games DROP COLUMN finish_reason;
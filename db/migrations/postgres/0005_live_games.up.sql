@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS live_games (
+	game_id TEXT PRIMARY KEY,
+	state JSONB NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,13 @@
+CREATE TABLE outbox (
+	id BIGSERIAL PRIMARY KEY,
+	event_type TEXT NOT NULL,
+	event_key TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	next_attempt_at TIMESTAMP NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	sent_at TIMESTAMP
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (next_attempt_at) WHERE sent_at IS NULL;
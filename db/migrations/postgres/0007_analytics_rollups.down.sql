@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS rollup_game_log;
+DROP TABLE IF EXISTS rollup_wins;
+DROP TABLE IF EXISTS rollup_durations;
+DROP TABLE IF EXISTS rollup_games;
@@ -0,0 +1,3 @@
+-- Games uploaded through POST /games/import are kept and exported but not
+-- counted in any player statistics
+ALTER TABLE games ADD COLUMN imported BOOLEAN NOT NULL DEFAULT FALSE;
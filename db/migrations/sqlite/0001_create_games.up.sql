@@ -0,0 +1,9 @@
+-- IF NOT EXISTS so deployments created before migrations adopt their table
+CREATE TABLE IF NOT EXISTS games (
+	game_id TEXT PRIMARY KEY,
+	player1 TEXT,
+	player2 TEXT,
+	winner TEXT,
+	created_at TIMESTAMP,
+	finished_at TIMESTAMP
+);
@@ -0,0 +1,32 @@
+-- Per-game facts needed for player statistics
+ALTER TABLE games ADD COLUMN finish_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE games ADD COLUMN move_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN duration_ms INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN player1_is_bot BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN player2_is_bot BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN rated BOOLEAN NOT NULL DEFAULT 0;
+
+-- 0003 backfilled every player as human; before bot profiles there was a
+-- single bot and this was its name
+UPDATE players SET is_bot = 1 WHERE username = 'Bot 🤖';
+
+-- Older rows stored the players in arbitrary order
+UPDATE games SET player1_is_bot = 1
+WHERE player1 IN (SELECT username FROM players WHERE is_bot);
+UPDATE games SET player2_is_bot = 1
+WHERE player2 IN (SELECT username FROM players WHERE is_bot);
+
+-- Materialized per-player state, updated when a game is saved. Streaks start
+-- counting from this migration.
+ALTER TABLE players ADD COLUMN rating INTEGER NOT NULL DEFAULT 1200;
+ALTER TABLE players ADD COLUMN current_streak INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE players ADD COLUMN best_streak INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE rating_history (
+	username TEXT NOT NULL,
+	game_id TEXT NOT NULL,
+	rating_before INTEGER NOT NULL,
+	rating_after INTEGER NOT NULL,
+	recorded_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (username, game_id)
+);
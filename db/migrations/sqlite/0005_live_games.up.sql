@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS live_games (
+	game_id TEXT PRIMARY KEY,
+	state TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
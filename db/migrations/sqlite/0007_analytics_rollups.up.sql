@@ -0,0 +1,40 @@
+-- Aggregates written by cmd/consumer from the analytics event stream
+
+-- Games per hour and per day, split by mode ('PvP', 'PvE', or '' when an old
+-- event didn't say). Buckets start on the hour/day in UTC.
+CREATE TABLE rollup_games (
+	period TEXT NOT NULL,
+	bucket TIMESTAMP NOT NULL,
+	mode TEXT NOT NULL,
+	started INTEGER NOT NULL DEFAULT 0,
+	finished INTEGER NOT NULL DEFAULT 0,
+	draws INTEGER NOT NULL DEFAULT 0,
+	timed INTEGER NOT NULL DEFAULT 0, -- finished games with a known duration
+	duration_ms INTEGER NOT NULL DEFAULT 0, -- total over the timed games
+	PRIMARY KEY (period, bucket, mode)
+);
+
+-- Histogram of finished game durations per day, for percentiles. le_ms is
+-- the bucket's inclusive upper bound.
+CREATE TABLE rollup_durations (
+	day TIMESTAMP NOT NULL,
+	le_ms INTEGER NOT NULL,
+	games INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (day, le_ms)
+);
+
+CREATE TABLE rollup_wins (
+	username TEXT PRIMARY KEY,
+	wins INTEGER NOT NULL DEFAULT 0,
+	last_win_at TIMESTAMP NOT NULL
+);
+
+-- Every game the rollups have counted. A start or finish is only counted
+-- once, so events replayed after a restart don't inflate the totals, and a
+-- finish can find its start.
+CREATE TABLE rollup_game_log (
+	game_id TEXT PRIMARY KEY,
+	mode TEXT NOT NULL DEFAULT '',
+	started_at TIMESTAMP,
+	finished_at TIMESTAMP
+);
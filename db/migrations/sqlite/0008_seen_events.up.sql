@@ -0,0 +1,8 @@
+-- Event IDs cmd/consumer has processed, kept for its dedupe window so
+-- redelivered events are dropped
+CREATE TABLE seen_events (
+	event_id TEXT PRIMARY KEY,
+	seen_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX seen_events_seen_at_idx ON seen_events (seen_at);
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutboxEvent is an analytics event waiting to be published. Events written
+// with SaveGame commit or roll back together with the game; the relay in the
+// server publishes them and marks them sent.
+type OutboxEvent struct {
+	ID        int64
+	Type      string
+	Key       string // partition key, usually the game ID
+	Payload   []byte // JSON
+	CreatedAt time.Time
+	Attempts  int
+}
+
+// addOutbox inserts events in the caller's transaction.
+func (s *SQLStore) addOutbox(tx *sql.Tx, events []OutboxEvent) error {
+	now := time.Now()
+	for _, e := range events {
+		created := e.CreatedAt
+		if created.IsZero() {
+			created = now
+		}
+		_, err := tx.Exec(`
+		INSERT INTO outbox (event_type, event_key, payload, created_at, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $4)
+		`, e.Type, e.Key, string(e.Payload), s.ts(created))
+		if err != nil {
+			return fmt.Errorf("save outbox event: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) AddOutbox(events ...OutboxEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.addOutbox(tx, events); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimOutbox leases up to limit due events, oldest first, so that relays on
+// other nodes skip them until the lease runs out.
+func (s *SQLStore) ClaimOutbox(limit int, lease time.Duration) ([]OutboxEvent, error) {
+	now := time.Now()
+	lock := ""
+	if s.dialect == DialectPostgres {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+
+	rows, err := s.db.Query(`
+	UPDATE outbox SET next_attempt_at = $1
+	WHERE id IN (
+		SELECT id FROM outbox WHERE sent_at IS NULL AND next_attempt_at <= $2
+		ORDER BY id LIMIT $3 `+lock+`
+	)
+	RETURNING id, event_type, event_key, payload, attempts
+	`, s.ts(now.Add(lease)), s.ts(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []OutboxEvent
+	for rows.Next() {
+		var e OutboxEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.Key, &e.Payload, &e.Attempts); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	// RETURNING has no defined order
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, rows.Err()
+}
+
+func (s *SQLStore) MarkOutboxSent(ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []any{s.ts(time.Now())}
+	marks := make([]string, len(ids))
+	for i, id := range ids {
+		args = append(args, id)
+		marks[i] = fmt.Sprintf("$%d", i+2)
+	}
+	_, err := s.db.Exec(`
+	UPDATE outbox SET sent_at = $1, last_error = NULL WHERE id IN (`+strings.Join(marks, ", ")+`)
+	`, args...)
+	return err
+}
+
+func (s *SQLStore) MarkOutboxFailed(id int64, cause error, retryAt time.Time) error {
+	_, err := s.db.Exec(`
+	UPDATE outbox SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3 WHERE id = $1
+	`, id, cause.Error(), s.ts(retryAt))
+	return err
+}
+
+// PruneOutbox deletes events sent before the cutoff.
+func (s *SQLStore) PruneOutbox(sentBefore time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM outbox WHERE sent_at IS NOT NULL AND sent_at < $1`, s.ts(sentBefore))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// memoryOutbox is MemoryStore's outbox. It holds only unsent events and has
+// its own lock, so the relay never waits on game saves and reads.
+type memoryOutbox struct {
+	mu      sync.Mutex
+	nextID  int64
+	entries map[int64]*memoryOutboxEntry
+	queue   []int64 // IDs of entries, oldest first; sent ones are skipped lazily
+}
+
+type memoryOutboxEntry struct {
+	OutboxEvent
+	nextAttempt time.Time
+	lastError   string
+}
+
+func (o *memoryOutbox) add(events []OutboxEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.entries == nil {
+		o.entries = make(map[int64]*memoryOutboxEntry)
+	}
+	now := time.Now()
+	for _, e := range events {
+		o.nextID++
+		e.ID = o.nextID
+		if e.CreatedAt.IsZero() {
+			e.CreatedAt = now
+		}
+		o.entries[e.ID] = &memoryOutboxEntry{OutboxEvent: e, nextAttempt: e.CreatedAt}
+		o.queue = append(o.queue, e.ID)
+	}
+}
+
+func (m *MemoryStore) AddOutbox(events ...OutboxEvent) error {
+	m.outbox.add(events)
+	return nil
+}
+
+func (m *MemoryStore) ClaimOutbox(limit int, lease time.Duration) ([]OutboxEvent, error) {
+	o := &m.outbox
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	var res []OutboxEvent
+	kept := o.queue[:0]
+	for _, id := range o.queue {
+		e := o.entries[id]
+		if e == nil {
+			continue // sent
+		}
+		kept = append(kept, id)
+		if len(res) < limit && !e.nextAttempt.After(now) {
+			e.nextAttempt = now.Add(lease)
+			res = append(res, e.OutboxEvent)
+		}
+	}
+	o.queue = kept
+	return res, nil
+}
+
+// MarkOutboxSent forgets the events: with nothing to inspect them after a
+// restart, keeping them would only grow the queue.
+func (m *MemoryStore) MarkOutboxSent(ids ...int64) error {
+	o := &m.outbox
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, id := range ids {
+		delete(o.entries, id)
+	}
+	return nil
+}
+
+func (m *MemoryStore) MarkOutboxFailed(id int64, cause error, retryAt time.Time) error {
+	o := &m.outbox
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if e := o.entries[id]; e != nil {
+		e.Attempts++
+		e.lastError, e.nextAttempt = cause.Error(), retryAt
+	}
+	return nil
+}
+
+// PruneOutbox has nothing to do: sent events are dropped straight away.
+func (m *MemoryStore) PruneOutbox(sentBefore time.Time) (int64, error) {
+	return 0, nil
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+
+	_ "github.com/lib/pq"
+)
+
+// OpenPostgres connects to Postgres and migrates the schema.
+func OpenPostgres(url string) (*SQLStore, error) {
+	// Connect to the DB
+	conn, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	// Test the connection
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	log.Println("✅ Successfully connected to PostgreSQL!")
+
+	if err := migrate(conn, DialectPostgres); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &SQLStore{db: conn, dialect: DialectPostgres}, nil
+}
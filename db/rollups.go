@@ -0,0 +1,531 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Rollup periods
+const (
+	RollupHour = "hour"
+	RollupDay  = "day"
+)
+
+// DurationBounds are the upper bounds, in milliseconds, of the game duration
+// histogram. Longer games land in a last bucket bounded by math.MaxInt64.
+var DurationBounds = []int64{
+	15_000, 30_000, 45_000, 60_000, 90_000, 120_000, 180_000, 240_000,
+	300_000, 420_000, 600_000, 900_000, 1_200_000, 1_800_000, 3_600_000,
+}
+
+// RollupStore holds the aggregates cmd/consumer builds from the analytics
+// events. Each Record call is atomic, and a game's start and finish are
+// counted once however often they are recorded.
+type RollupStore interface {
+	RecordGameStarted(g StartedGame) error
+	RecordGameFinished(g FinishedGame) error
+
+	// GameBuckets returns the buckets of a period starting in [since, until),
+	// oldest first, one row per mode.
+	GameBuckets(period string, since, until time.Time) ([]GameBucket, error)
+	// DurationHistogram sums the duration histogram of the days in
+	// [since, until), by ascending bound.
+	DurationHistogram(since, until time.Time) ([]DurationBucket, error)
+	TopWinners(limit int) ([]WinCount, error)
+
+	// The seen-event log lets the consumer drop redelivered events. Entries
+	// are pruned once they are older than its dedupe window.
+	EventSeen(id string) (bool, error)
+	MarkEventSeen(id string, at time.Time) error
+	PruneSeenEvents(before time.Time) (int64, error)
+	// PruneGameLog forgets games that finished, or started and never
+	// finished, before the cutoff. Their start or finish is then no longer
+	// recognised as counted, so the cutoff should match the dedupe window.
+	PruneGameLog(before time.Time) (int64, error)
+
+	Close() error
+}
+
+type StartedGame struct {
+	GameID string
+	Mode   string
+	At     time.Time
+}
+
+type FinishedGame struct {
+	GameID   string
+	Mode     string // "" takes the mode from the start
+	Winner   string // username; "" for a draw
+	Duration time.Duration
+	At       time.Time
+}
+
+type GameBucket struct {
+	Bucket     time.Time `json:"bucket"`
+	Mode       string    `json:"mode"`
+	Started    int       `json:"started"`
+	Finished   int       `json:"finished"`
+	Draws      int       `json:"draws"`
+	Timed      int       `json:"timed"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+type DurationBucket struct {
+	LeMs  int64 `json:"le_ms"`
+	Games int   `json:"games"`
+}
+
+type WinCount struct {
+	Username  string    `json:"username"`
+	Wins      int       `json:"wins"`
+	LastWinAt time.Time `json:"last_win_at"`
+}
+
+func bucketStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	if period == RollupDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+func durationBound(ms int64) int64 {
+	i := sort.Search(len(DurationBounds), func(i int) bool { return DurationBounds[i] >= ms })
+	if i == len(DurationBounds) {
+		return math.MaxInt64
+	}
+	return DurationBounds[i]
+}
+
+// ---------------------------------------------------------------------------
+// SQL
+
+func (s *SQLStore) RecordGameStarted(g StartedGame) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+	INSERT INTO rollup_game_log (game_id, mode, started_at) VALUES ($1, $2, $3)
+	ON CONFLICT (game_id) DO UPDATE SET mode = excluded.mode, started_at = excluded.started_at
+	WHERE rollup_game_log.started_at IS NULL
+	`, g.GameID, g.Mode, s.ts(g.At))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err // already counted
+	}
+	for _, period := range []string{RollupHour, RollupDay} {
+		if err := s.bumpGames(tx, period, g.At, GameBucket{Mode: g.Mode, Started: 1}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) RecordGameFinished(g FinishedGame) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var mode string
+	var startedAt, finishedAt sql.NullTime
+	err = tx.QueryRow(`SELECT mode, started_at, finished_at FROM rollup_game_log WHERE game_id = $1`, g.GameID).Scan(&mode, &startedAt, &finishedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if finishedAt.Valid {
+		return nil // already counted
+	}
+	if g.Mode == "" {
+		g.Mode = mode
+	}
+	if g.Duration <= 0 && startedAt.Valid {
+		g.Duration = g.At.Sub(startedAt.Time)
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO rollup_game_log (game_id, mode, finished_at) VALUES ($1, $2, $3)
+	ON CONFLICT (game_id) DO UPDATE SET finished_at = excluded.finished_at
+	`, g.GameID, g.Mode, s.ts(g.At))
+	if err != nil {
+		return err
+	}
+
+	b := finishedBucket(g)
+	for _, period := range []string{RollupHour, RollupDay} {
+		if err := s.bumpGames(tx, period, g.At, b); err != nil {
+			return err
+		}
+	}
+
+	if b.Timed > 0 {
+		_, err = tx.Exec(`
+		INSERT INTO rollup_durations (day, le_ms, games) VALUES ($1, $2, 1)
+		ON CONFLICT (day, le_ms) DO UPDATE SET games = rollup_durations.games + 1
+		`, s.ts(bucketStart(RollupDay, g.At)), durationBound(b.DurationMs))
+		if err != nil {
+			return fmt.Errorf("save duration: %w", err)
+		}
+	}
+
+	if g.Winner != "" {
+		_, err = tx.Exec(`
+		INSERT INTO rollup_wins (username, wins, last_win_at) VALUES ($1, 1, $2)
+		ON CONFLICT (username) DO UPDATE SET wins = rollup_wins.wins + 1, last_win_at = $2
+		`, g.Winner, s.ts(g.At))
+		if err != nil {
+			return fmt.Errorf("save win: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// finishedBucket is what one finished game adds to its buckets.
+func finishedBucket(g FinishedGame) GameBucket {
+	b := GameBucket{Mode: g.Mode, Finished: 1}
+	if g.Winner == "" {
+		b.Draws = 1
+	}
+	if g.Duration > 0 {
+		b.Timed, b.DurationMs = 1, g.Duration.Milliseconds()
+	}
+	return b
+}
+
+// bumpGames adds b's counts to the bucket of the period holding t.
+func (s *SQLStore) bumpGames(tx *sql.Tx, period string, t time.Time, b GameBucket) error {
+	_, err := tx.Exec(`
+	INSERT INTO rollup_games (period, bucket, mode, started, finished, draws, timed, duration_ms)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (period, bucket, mode) DO UPDATE SET
+		started = rollup_games.started + excluded.started,
+		finished = rollup_games.finished + excluded.finished,
+		draws = rollup_games.draws + excluded.draws,
+		timed = rollup_games.timed + excluded.timed,
+		duration_ms = rollup_games.duration_ms + excluded.duration_ms
+	`, period, s.ts(bucketStart(period, t)), b.Mode, b.Started, b.Finished, b.Draws, b.Timed, b.DurationMs)
+	if err != nil {
+		return fmt.Errorf("save %s rollup: %w", period, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) GameBuckets(period string, since, until time.Time) ([]GameBucket, error) {
+	rows, err := s.db.Query(`
+	SELECT bucket, mode, started, finished, draws, timed, duration_ms FROM rollup_games
+	WHERE period = $1 AND bucket >= $2 AND bucket < $3
+	ORDER BY bucket, mode
+	`, period, s.ts(since), s.ts(until))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []GameBucket
+	for rows.Next() {
+		var b GameBucket
+		if err := rows.Scan(&b.Bucket, &b.Mode, &b.Started, &b.Finished, &b.Draws, &b.Timed, &b.DurationMs); err != nil {
+			return nil, err
+		}
+		b.Bucket = b.Bucket.UTC()
+		res = append(res, b)
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLStore) DurationHistogram(since, until time.Time) ([]DurationBucket, error) {
+	rows, err := s.db.Query(`
+	SELECT le_ms, SUM(games) FROM rollup_durations
+	WHERE day >= $1 AND day < $2
+	GROUP BY le_ms ORDER BY le_ms
+	`, s.ts(since), s.ts(until))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []DurationBucket
+	for rows.Next() {
+		var b DurationBucket
+		if err := rows.Scan(&b.LeMs, &b.Games); err != nil {
+			return nil, err
+		}
+		res = append(res, b)
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLStore) TopWinners(limit int) ([]WinCount, error) {
+	rows, err := s.db.Query(`
+	SELECT username, wins, last_win_at FROM rollup_wins
+	ORDER BY wins DESC, username LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []WinCount
+	for rows.Next() {
+		var w WinCount
+		if err := rows.Scan(&w.Username, &w.Wins, &w.LastWinAt); err != nil {
+			return nil, err
+		}
+		w.LastWinAt = w.LastWinAt.UTC()
+		res = append(res, w)
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLStore) EventSeen(id string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM seen_events WHERE event_id = $1`, id).Scan(&n)
+	return n > 0, err
+}
+
+func (s *SQLStore) MarkEventSeen(id string, at time.Time) error {
+	_, err := s.db.Exec(`
+	INSERT INTO seen_events (event_id, seen_at) VALUES ($1, $2)
+	ON CONFLICT (event_id) DO NOTHING
+	`, id, s.ts(at))
+	return err
+}
+
+func (s *SQLStore) PruneSeenEvents(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM seen_events WHERE seen_at < $1`, s.ts(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQLStore) PruneGameLog(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`
+	DELETE FROM rollup_game_log
+	WHERE finished_at < $1 OR (finished_at IS NULL AND started_at < $1)
+	`, s.ts(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ---------------------------------------------------------------------------
+// Memory
+
+// memoryRollups is MemoryStore's rollup state; the caller holds the store's lock.
+type memoryRollups struct {
+	games     map[rollupKey]*GameBucket
+	durations map[durationKey]int
+	wins      map[string]*WinCount
+	log       map[string]*gameLog
+	seen      map[string]time.Time
+}
+
+// gameLog mirrors a rollup_game_log row.
+type gameLog struct {
+	mode                  string
+	started, finished     bool
+	startedAt, finishedAt time.Time
+}
+
+type rollupKey struct {
+	period string
+	bucket time.Time
+	mode   string
+}
+
+type durationKey struct {
+	day  time.Time
+	leMs int64
+}
+
+func (r *memoryRollups) init() {
+	if r.games == nil {
+		r.games = make(map[rollupKey]*GameBucket)
+		r.durations = make(map[durationKey]int)
+		r.wins = make(map[string]*WinCount)
+		r.log = make(map[string]*gameLog)
+		r.seen = make(map[string]time.Time)
+	}
+}
+
+func (r *memoryRollups) game(id string) *gameLog {
+	l := r.log[id]
+	if l == nil {
+		l = &gameLog{}
+		r.log[id] = l
+	}
+	return l
+}
+
+func (r *memoryRollups) bump(period string, t time.Time, add GameBucket) {
+	k := rollupKey{period, bucketStart(period, t), add.Mode}
+	b := r.games[k]
+	if b == nil {
+		b = &GameBucket{Bucket: k.bucket, Mode: k.mode}
+		r.games[k] = b
+	}
+	b.Started += add.Started
+	b.Finished += add.Finished
+	b.Draws += add.Draws
+	b.Timed += add.Timed
+	b.DurationMs += add.DurationMs
+}
+
+func (m *MemoryStore) RecordGameStarted(g StartedGame) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollups.init()
+	l := m.rollups.game(g.GameID)
+	if l.started {
+		return nil // already counted
+	}
+	l.mode, l.started, l.startedAt = g.Mode, true, g.At
+	for _, period := range []string{RollupHour, RollupDay} {
+		m.rollups.bump(period, g.At, GameBucket{Mode: g.Mode, Started: 1})
+	}
+	return nil
+}
+
+func (m *MemoryStore) RecordGameFinished(g FinishedGame) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollups.init()
+	l := m.rollups.game(g.GameID)
+	if l.finished {
+		return nil // already counted
+	}
+	l.finished, l.finishedAt = true, g.At
+	if g.Mode == "" {
+		g.Mode = l.mode
+	}
+	if g.Duration <= 0 && l.started {
+		g.Duration = g.At.Sub(l.startedAt)
+	}
+
+	b := finishedBucket(g)
+	for _, period := range []string{RollupHour, RollupDay} {
+		m.rollups.bump(period, g.At, b)
+	}
+	if b.Timed > 0 {
+		m.rollups.durations[durationKey{bucketStart(RollupDay, g.At), durationBound(b.DurationMs)}]++
+	}
+	if g.Winner != "" {
+		w := m.rollups.wins[g.Winner]
+		if w == nil {
+			w = &WinCount{Username: g.Winner}
+			m.rollups.wins[g.Winner] = w
+		}
+		w.Wins++
+		w.LastWinAt = g.At.UTC()
+	}
+	return nil
+}
+
+func (m *MemoryStore) GameBuckets(period string, since, until time.Time) ([]GameBucket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var res []GameBucket
+	for k, b := range m.rollups.games {
+		if k.period == period && !k.bucket.Before(since) && k.bucket.Before(until) {
+			res = append(res, *b)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].Bucket.Equal(res[j].Bucket) {
+			return res[i].Bucket.Before(res[j].Bucket)
+		}
+		return res[i].Mode < res[j].Mode
+	})
+	return res, nil
+}
+
+func (m *MemoryStore) DurationHistogram(since, until time.Time) ([]DurationBucket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sums := make(map[int64]int)
+	for k, n := range m.rollups.durations {
+		if !k.day.Before(since) && k.day.Before(until) {
+			sums[k.leMs] += n
+		}
+	}
+	res := make([]DurationBucket, 0, len(sums))
+	for le, n := range sums {
+		res = append(res, DurationBucket{LeMs: le, Games: n})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].LeMs < res[j].LeMs })
+	return res, nil
+}
+
+func (m *MemoryStore) TopWinners(limit int) ([]WinCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]WinCount, 0, len(m.rollups.wins))
+	for _, w := range m.rollups.wins {
+		res = append(res, *w)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Wins != res[j].Wins {
+			return res[i].Wins > res[j].Wins
+		}
+		return res[i].Username < res[j].Username
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) EventSeen(id string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.rollups.seen[id]
+	return ok, nil
+}
+
+func (m *MemoryStore) MarkEventSeen(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollups.init()
+	if _, ok := m.rollups.seen[id]; !ok {
+		m.rollups.seen[id] = at
+	}
+	return nil
+}
+
+func (m *MemoryStore) PruneSeenEvents(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, at := range m.rollups.seen {
+		if at.Before(before) {
+			delete(m.rollups.seen, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) PruneGameLog(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, l := range m.rollups.log {
+		if (l.finished && l.finishedAt.Before(before)) || (!l.finished && l.startedAt.Before(before)) {
+			delete(m.rollups.log, id)
+			n++
+		}
+	}
+	return n, nil
+}
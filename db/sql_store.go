@@ -0,0 +1,530 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"fourinrow/game"
+)
+
+// SQLStore implements Store on Postgres and SQLite. The queries are shared;
+// the dialect only changes how timestamps are written.
+type SQLStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+// sqliteTime is fixed-width so SQLite's text timestamps compare correctly.
+const sqliteTime = "2006-01-02 15:04:05.000000"
+
+// ts converts a time for use as a query argument.
+func (s *SQLStore) ts(t time.Time) any {
+	if s.dialect == DialectSQLite {
+		return t.UTC().Format(sqliteTime)
+	}
+	return t
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) SaveGame(g *game.Game, events ...OutboxEvent) error {
+	rec, p1, p2 := gameRecord(g, time.Now())
+
+	// Don't save if there is no winner, but don't lose the events either
+	if rec.Winner == "" {
+		return s.AddOutbox(events...)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Stats and ratings must only count a game once, however often it is saved
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM games WHERE game_id = $1)`, g.ID).Scan(&exists); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO games (game_id, player1, player2, winner, created_at, finished_at,
+		finish_reason, move_count, duration_ms, player1_is_bot, player2_is_bot, rated)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	ON CONFLICT (game_id) DO UPDATE SET winner=$4, finished_at=$6,
+		finish_reason=$7, move_count=$8, duration_ms=$9
+	`, rec.ID, rec.Player1, rec.Player2, rec.Winner, s.ts(rec.CreatedAt), s.ts(rec.FinishedAt),
+		rec.FinishReason, rec.MoveCount, rec.DurationMs, rec.Player1IsBot, rec.Player2IsBot, rec.Rated)
+	if err != nil {
+		return fmt.Errorf("save game: %w", err)
+	}
+
+	for _, p := range []*game.Player{p1, p2} {
+		if p == nil {
+			continue
+		}
+		_, err = tx.Exec(`
+		INSERT INTO players (username, is_bot, first_seen_at, last_seen_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (username) DO UPDATE SET is_bot=$2, last_seen_at=$3
+		`, p.Username, p.IsBot, s.ts(rec.FinishedAt))
+		if err != nil {
+			return fmt.Errorf("save player: %w", err)
+		}
+	}
+
+	if !exists && p1 != nil && p2 != nil {
+		if err := s.recordResult(tx, rec); err != nil {
+			return fmt.Errorf("update player stats: %w", err)
+		}
+	}
+
+	if err := s.saveMoves(tx, g); err != nil {
+		return err
+	}
+	if err := s.addOutbox(tx, events); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) saveMoves(tx *sql.Tx, g *game.Game) error {
+	if _, err := tx.Exec(`DELETE FROM moves WHERE game_id = $1`, g.ID); err != nil {
+		return fmt.Errorf("save moves: %w", err)
+	}
+	for _, m := range moveRecords(g) {
+		_, err := tx.Exec(`
+		INSERT INTO moves (game_id, ply, player, color, col, row_index, played_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, g.ID, m.Ply, m.Player, m.Color, m.Column, m.Row, s.ts(m.PlayedAt))
+		if err != nil {
+			return fmt.Errorf("save moves: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) ImportGame(g *game.Game) error {
+	rec, _, _ := gameRecord(g, time.Now())
+	if rec.Winner == "" {
+		return errors.New("game has no result")
+	}
+	rec.Rated = false
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+	INSERT INTO games (game_id, player1, player2, winner, created_at, finished_at,
+		finish_reason, move_count, duration_ms, player1_is_bot, player2_is_bot, rated, imported)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, TRUE)
+	ON CONFLICT (game_id) DO NOTHING
+	`, rec.ID, rec.Player1, rec.Player2, rec.Winner, s.ts(rec.CreatedAt), s.ts(rec.FinishedAt),
+		rec.FinishReason, rec.MoveCount, rec.DurationMs, rec.Player1IsBot, rec.Player2IsBot, rec.Rated)
+	if err != nil {
+		return fmt.Errorf("save game: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if err := s.saveMoves(tx, g); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordResult updates the materialized streak and rating columns.
+func (s *SQLStore) recordResult(tx *sql.Tx, rec GameRecord) error {
+	load := func(name string) (*playerState, error) {
+		st := &playerState{Username: name}
+		err := tx.QueryRow(`
+		SELECT is_bot, rating, current_streak, best_streak FROM players WHERE username = $1
+		`, name).Scan(&st.IsBot, &st.Rating, &st.CurrentStreak, &st.BestStreak)
+		return st, err
+	}
+
+	p1, err := load(rec.Player1)
+	if err != nil {
+		return err
+	}
+	p2, err := load(rec.Player2)
+	if err != nil {
+		return err
+	}
+
+	history := applyResult(p1, p2, rec.Winner, rec.Rated, rec.ID, rec.FinishedAt)
+
+	for _, p := range []*playerState{p1, p2} {
+		_, err := tx.Exec(`
+		UPDATE players SET rating=$2, current_streak=$3, best_streak=$4 WHERE username = $1
+		`, p.Username, p.Rating, p.CurrentStreak, p.BestStreak)
+		if err != nil {
+			return err
+		}
+	}
+	for _, h := range history {
+		_, err := tx.Exec(`
+		INSERT INTO rating_history (username, game_id, rating_before, rating_after, recorded_at)
+		VALUES ($1, $2, $3, $4, $5)
+		`, h.Username, h.GameID, h.Before, h.After, s.ts(h.RecordedAt))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const gameColumns = `game_id, COALESCE(player1, ''), COALESCE(player2, ''), COALESCE(winner, ''),
+	player1_is_bot, player2_is_bot, finish_reason, rated, imported, move_count, duration_ms, created_at, finished_at`
+
+func scanGame(row interface{ Scan(...any) error }) (GameRecord, error) {
+	var g GameRecord
+	var created, finished sql.NullTime
+	err := row.Scan(&g.ID, &g.Player1, &g.Player2, &g.Winner,
+		&g.Player1IsBot, &g.Player2IsBot, &g.FinishReason, &g.Rated, &g.Imported, &g.MoveCount, &g.DurationMs,
+		&created, &finished)
+	g.CreatedAt, g.FinishedAt = created.Time, finished.Time
+	return g, err
+}
+
+// GetGame returns nil (and no error) when the game does not exist.
+func (s *SQLStore) GetGame(id string) (*GameRecord, error) {
+	g, err := scanGame(s.db.QueryRow(`SELECT `+gameColumns+` FROM games WHERE game_id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ListGames returns matching games, most recently finished first.
+func (s *SQLStore) ListGames(f GameFilter) ([]GameRecord, error) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Player != "" && f.Opponent != "" {
+		p, o := arg(f.Player), arg(f.Opponent)
+		where = append(where, "((player1 = "+p+" AND player2 = "+o+") OR (player1 = "+o+" AND player2 = "+p+"))")
+	} else if f.Player != "" {
+		p := arg(f.Player)
+		where = append(where, "(player1 = "+p+" OR player2 = "+p+")")
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "finished_at >= "+arg(s.ts(f.Since)))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "finished_at < "+arg(s.ts(f.Until)))
+	}
+	if f.Played {
+		where = append(where, "NOT imported")
+	}
+
+	q := `SELECT ` + gameColumns + ` FROM games`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	q += ` ORDER BY finished_at DESC`
+	if f.Limit > 0 {
+		q += ` LIMIT ` + arg(f.Limit)
+	}
+
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []GameRecord
+	for rows.Next() {
+		g, err := scanGame(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, g)
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLStore) GetMoves(gameID string) ([]MoveRecord, error) {
+	rows, err := s.db.Query(`
+	SELECT ply, player, color, col, row_index, played_at FROM moves
+	WHERE game_id = $1 ORDER BY ply
+	`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []MoveRecord
+	for rows.Next() {
+		var m MoveRecord
+		var at sql.NullTime
+		if err := rows.Scan(&m.Ply, &m.Player, &m.Color, &m.Column, &m.Row, &at); err != nil {
+			return nil, err
+		}
+		m.PlayedAt = at.Time
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
+
+// GetPlayer returns nil (and no error) for an unknown player.
+func (s *SQLStore) GetPlayer(name string) (*PlayerRecord, error) {
+	var p PlayerRecord
+	var first, last sql.NullTime
+	err := s.db.QueryRow(`
+	SELECT username, is_bot, rating, current_streak, best_streak, first_seen_at, last_seen_at
+	FROM players WHERE username = $1
+	`, name).Scan(&p.Username, &p.IsBot, &p.Rating, &p.CurrentStreak, &p.BestStreak, &first, &last)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.FirstSeenAt, p.LastSeenAt = first.Time, last.Time
+	return &p, nil
+}
+
+// GetPlayerProfile returns nil (and no error) for an unknown player.
+func (s *SQLStore) GetPlayerProfile(name string, recentGames int) (*PlayerProfile, error) {
+	p, err := s.GetPlayer(name)
+	if p == nil || err != nil {
+		return nil, err
+	}
+
+	// One pass over the player's games, split by colour and opponent type
+	rows, err := s.db.Query(`
+	SELECT
+		CASE WHEN player1 = $1 THEN 1 ELSE 2 END,
+		CASE WHEN player1 = $1 THEN player2_is_bot ELSE player1_is_bot END,
+		COUNT(*),
+		SUM(CASE WHEN winner = $1 THEN 1 ELSE 0 END),
+		SUM(CASE WHEN winner = 'draw' THEN 1 ELSE 0 END),
+		SUM(move_count),
+		SUM(duration_ms)
+	FROM games
+	WHERE (player1 = $1 OR player2 = $1) AND NOT imported
+	GROUP BY 1, 2
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []statBucket
+	for rows.Next() {
+		var b statBucket
+		if err := rows.Scan(&b.Color, &b.OppBot, &b.Games, &b.Wins, &b.Draws, &b.Moves, &b.DurationMs); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	history, err := s.ratingHistory(name, 50)
+	if err != nil {
+		return nil, err
+	}
+	// A zero Limit would list every game
+	var recent []GameRecord
+	if recentGames > 0 {
+		if recent, err = s.ListGames(GameFilter{Player: name, Limit: recentGames, Played: true}); err != nil {
+			return nil, err
+		}
+	}
+	return buildProfile(p, buckets, history, recent), nil
+}
+
+func (s *SQLStore) GetHeadToHead(a, b string, recentGames int) (*HeadToHead, error) {
+	games, err := s.ListGames(GameFilter{Player: a, Opponent: b, Played: true})
+	if err != nil {
+		return nil, err
+	}
+	return headToHead(a, b, games, recentGames), nil
+}
+
+func (s *SQLStore) GetHeadToHeadTotals(a, b string) (*HeadToHead, error) {
+	const pair = `((player1 = $1 AND player2 = $2) OR (player1 = $2 AND player2 = $1)) AND NOT imported`
+	h := &HeadToHead{PlayerA: a, PlayerB: b, Recent: []RivalryResult{}}
+	err := s.db.QueryRow(`
+	SELECT COUNT(*),
+		COALESCE(SUM(CASE WHEN winner = $1 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN winner = $2 THEN 1 ELSE 0 END), 0)
+	FROM games WHERE `+pair, a, b).Scan(&h.Games, &h.WinsA, &h.WinsB)
+	if err != nil || h.Games == 0 {
+		return h, err
+	}
+	h.Draws = h.Games - h.WinsA - h.WinsB
+
+	var last string
+	err = s.db.QueryRow(`SELECT winner FROM games WHERE `+pair+` ORDER BY finished_at DESC LIMIT 1`, a, b).Scan(&last)
+	if err != nil {
+		return nil, err
+	}
+	if last != a && last != b {
+		return h, nil // a draw ends any streak
+	}
+	// The streak is every game since the last one the leader did not win
+	h.CurrentStreak.Username = last
+	err = s.db.QueryRow(`
+	SELECT COUNT(*) FROM games WHERE `+pair+` AND finished_at > COALESCE(
+		(SELECT MAX(finished_at) FROM games WHERE `+pair+` AND winner <> $3), $4)
+	`, a, b, last, s.ts(time.Time{})).Scan(&h.CurrentStreak.Length)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ratingHistory returns the player's last `limit` rating changes, oldest first.
+func (s *SQLStore) ratingHistory(name string, limit int) ([]RatingPoint, error) {
+	rows, err := s.db.Query(`
+	SELECT game_id, rating_before, rating_after, recorded_at FROM rating_history
+	WHERE username = $1 ORDER BY recorded_at DESC LIMIT $2
+	`, name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []RatingPoint
+	for rows.Next() {
+		var r RatingPoint
+		if err := rows.Scan(&r.GameID, &r.Before, &r.After, &r.RecordedAt); err != nil {
+			return nil, err
+		}
+		res = append([]RatingPoint{r}, res...)
+	}
+	return res, rows.Err()
+}
+
+// GetStandings totals every human player's games finished since `since`.
+func (s *SQLStore) GetStandings(since time.Time) ([]LeaderboardEntry, error) {
+	rows, err := s.db.Query(`
+	SELECT p.username, p.rating, p.best_streak,
+		COUNT(*),
+		SUM(CASE WHEN g.winner = p.username THEN 1 ELSE 0 END),
+		SUM(CASE WHEN g.winner = 'draw' THEN 1 ELSE 0 END)
+	FROM players p
+	JOIN games g ON g.player1 = p.username OR g.player2 = p.username
+	WHERE NOT p.is_bot AND NOT g.imported AND g.finished_at >= $1
+	GROUP BY p.username, p.rating, p.best_streak
+	`, s.ts(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []LeaderboardEntry
+	for rows.Next() {
+		var e LeaderboardEntry
+		if err := rows.Scan(&e.Username, &e.Rating, &e.BestStreak, &e.Games, &e.TotalWins, &e.Draws); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The players table only knows all-time streaks
+	if !since.IsZero() {
+		games, err := s.ListGames(GameFilter{Since: since, Played: true})
+		if err != nil {
+			return nil, err
+		}
+		res = withPeriodStreaks(res, games)
+	}
+	return finishStandings(res), nil
+}
+
+func (s *SQLStore) SaveLiveGame(snap *game.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+	INSERT INTO live_games (game_id, state, updated_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (game_id) DO UPDATE SET state=$2, updated_at=$3
+	`, snap.ID, string(data), s.ts(time.Now()))
+	return err
+}
+
+func (s *SQLStore) DeleteLiveGame(gameID string) error {
+	_, err := s.db.Exec(`DELETE FROM live_games WHERE game_id = $1`, gameID)
+	return err
+}
+
+func (s *SQLStore) ListLiveGames() ([]*game.Snapshot, error) {
+	rows, err := s.db.Query(`SELECT state FROM live_games ORDER BY updated_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []*game.Snapshot
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var snap game.Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("decode snapshot: %w", err)
+		}
+		res = append(res, &snap)
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLStore) SaveReport(rep *game.Report) error {
+	data, err := json.Marshal(rep)
+	if err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+	INSERT INTO game_reports (game_id, report, created_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (game_id) DO UPDATE SET report=$2, created_at=$3
+	`, rep.GameID, string(data), s.ts(rep.CreatedAt))
+	return err
+}
+
+// GetReport returns nil (and no error) when the game has no report yet.
+func (s *SQLStore) GetReport(gameID string) (*game.Report, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT report FROM game_reports WHERE game_id = $1`, gameID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rep game.Report
+	if err := json.Unmarshal(data, &rep); err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+
+	_ "modernc.org/sqlite"
+)
+
+// OpenSQLite opens (or creates) a SQLite database file and migrates it. The
+// driver is pure Go, so no cgo or system library is needed.
+func OpenSQLite(path string) (*SQLStore, error) {
+	conn, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows one writer at a time; serialise in the pool instead of
+	// surfacing SQLITE_BUSY to callers.
+	conn.SetMaxOpenConns(1)
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	log.Printf("✅ Using SQLite database %s", path)
+
+	if err := migrate(conn, DialectSQLite); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &SQLStore{db: conn, dialect: DialectSQLite}, nil
+}
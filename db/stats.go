@@ -0,0 +1,199 @@
+package db
+
+import (
+	"math"
+	"time"
+
+	"fourinrow/game"
+)
+
+// Split is a win/loss/draw breakdown for a subset of a player's games.
+type Split struct {
+	Games   int     `json:"games"`
+	Wins    int     `json:"wins"`
+	Losses  int     `json:"losses"`
+	Draws   int     `json:"draws"`
+	WinRate float64 `json:"winRate"` // wins / games, 0..1
+}
+
+func (s *Split) add(o Split) {
+	s.Games += o.Games
+	s.Wins += o.Wins
+	s.Draws += o.Draws
+	s.Losses = s.Games - s.Wins - s.Draws
+	s.WinRate = ratio(s.Wins, s.Games)
+}
+
+type RatingPoint struct {
+	GameID     string    `json:"gameId"`
+	Before     int       `json:"before"`
+	After      int       `json:"after"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
+// PlayerProfile is the full statistics view served at GET /players/{name}.
+type PlayerProfile struct {
+	Username      string `json:"username"`
+	IsBot         bool   `json:"isBot"`
+	Rating        int    `json:"rating"`
+	CurrentStreak int    `json:"currentStreak"` // consecutive wins
+	BestStreak    int    `json:"bestStreak"`
+
+	Split
+	AvgMoves       float64 `json:"avgMoves"`       // plies per game
+	AvgDurationSec float64 `json:"avgDurationSec"` // wall-clock seconds per game
+
+	AsFirst  Split `json:"asFirst"`  // moved first (red)
+	AsSecond Split `json:"asSecond"` // moved second (yellow)
+	VsBots   Split `json:"vsBots"`
+	VsHumans Split `json:"vsHumans"`
+
+	RatingHistory []RatingPoint `json:"ratingHistory"`
+	RecentGames   []GameRecord  `json:"recentGames"`
+}
+
+// statBucket aggregates a player's games by colour and opponent type. The SQL
+// stores fill these with one GROUP BY query; MemoryStore builds them by hand.
+type statBucket struct {
+	Color      int
+	OppBot     bool
+	Games      int
+	Wins       int
+	Draws      int
+	Moves      int64
+	DurationMs int64
+}
+
+func buildProfile(p *PlayerRecord, buckets []statBucket, history []RatingPoint, recent []GameRecord) *PlayerProfile {
+	prof := &PlayerProfile{
+		Username:      p.Username,
+		IsBot:         p.IsBot,
+		Rating:        p.Rating,
+		CurrentStreak: p.CurrentStreak,
+		BestStreak:    p.BestStreak,
+		RatingHistory: history,
+		RecentGames:   recent,
+	}
+
+	var moves, duration int64
+	for _, b := range buckets {
+		s := Split{Games: b.Games, Wins: b.Wins, Draws: b.Draws}
+		prof.Split.add(s)
+		if b.Color == 1 {
+			prof.AsFirst.add(s)
+		} else {
+			prof.AsSecond.add(s)
+		}
+		if b.OppBot {
+			prof.VsBots.add(s)
+		} else {
+			prof.VsHumans.add(s)
+		}
+		moves += b.Moves
+		duration += b.DurationMs
+	}
+
+	if prof.Games > 0 {
+		prof.AvgMoves = round1(float64(moves) / float64(prof.Games))
+		prof.AvgDurationSec = round1(float64(duration) / 1000 / float64(prof.Games))
+	}
+	if prof.RatingHistory == nil {
+		prof.RatingHistory = []RatingPoint{}
+	}
+	if prof.RecentGames == nil {
+		prof.RecentGames = []GameRecord{}
+	}
+	return prof
+}
+
+// playerState is the materialized part of a player row that changes after
+// every game.
+type playerState struct {
+	Username      string
+	IsBot         bool
+	Rating        int
+	CurrentStreak int
+	BestStreak    int
+}
+
+// applyResult updates both players' streaks and, for rated games between two
+// humans, their ratings. It returns the rating history rows to record.
+func applyResult(p1, p2 *playerState, winner string, rated bool, gameID string, at time.Time) []ratingRow {
+	for _, p := range []*playerState{p1, p2} {
+		if p.Username == winner {
+			p.CurrentStreak++
+			if p.CurrentStreak > p.BestStreak {
+				p.BestStreak = p.CurrentStreak
+			}
+		} else {
+			p.CurrentStreak = 0
+		}
+	}
+
+	if !rated || p1.IsBot || p2.IsBot {
+		return nil
+	}
+
+	score := 0.5
+	switch winner {
+	case p1.Username:
+		score = 1
+	case p2.Username:
+		score = 0
+	}
+
+	before1, before2 := p1.Rating, p2.Rating
+	p1.Rating, p2.Rating = game.Elo(p1.Rating, p2.Rating, score)
+	return []ratingRow{
+		{Username: p1.Username, RatingPoint: RatingPoint{GameID: gameID, Before: before1, After: p1.Rating, RecordedAt: at}},
+		{Username: p2.Username, RatingPoint: RatingPoint{GameID: gameID, Before: before2, After: p2.Rating, RecordedAt: at}},
+	}
+}
+
+type ratingRow struct {
+	Username string
+	RatingPoint
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return math.Round(float64(a)/float64(b)*1000) / 1000
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// withPeriodStreaks replaces all-time best streaks with the longest streak in
+// `games`, which must be every game of the period, newest first.
+func withPeriodStreaks(entries []LeaderboardEntry, games []GameRecord) []LeaderboardEntry {
+	current := make(map[string]int)
+	best := make(map[string]int)
+	for i := len(games) - 1; i >= 0; i-- {
+		g := games[i]
+		for _, name := range []string{g.Player1, g.Player2} {
+			if g.Winner != name {
+				current[name] = 0
+				continue
+			}
+			current[name]++
+			best[name] = max(best[name], current[name])
+		}
+	}
+
+	for i := range entries {
+		entries[i].BestStreak = best[entries[i].Username]
+	}
+	return entries
+}
+
+func finishStandings(entries []LeaderboardEntry) []LeaderboardEntry {
+	for i := range entries {
+		e := &entries[i]
+		e.Losses = e.Games - e.TotalWins - e.Draws
+		e.WinRate = ratio(e.TotalWins, e.Games)
+	}
+	return entries
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"fourinrow/game"
+)
+
+// Store is everything the server persists. There are three implementations:
+// Postgres and SQLite (both SQLStore) and MemoryStore.
+type Store interface {
+	// Games and their moves. SaveGame is an upsert keyed by game ID; any
+	// events are added to the outbox in the same transaction.
+	SaveGame(g *game.Game, events ...OutboxEvent) error
+	// ImportGame stores a finished game from elsewhere. It is listed and
+	// exported like any other, but creates no players and counts in no
+	// statistics, streaks, ratings or standings. An existing ID is kept.
+	ImportGame(g *game.Game) error
+	GetGame(id string) (*GameRecord, error)
+	ListGames(f GameFilter) ([]GameRecord, error)
+	GetMoves(gameID string) ([]MoveRecord, error)
+
+	// Players are created the first time they finish a game. Their streaks
+	// and rating are updated by SaveGame.
+	GetPlayer(username string) (*PlayerRecord, error)
+	GetPlayerProfile(username string, recentGames int) (*PlayerProfile, error) // recentGames 0 lists none
+	GetHeadToHead(a, b string, recentGames int) (*HeadToHead, error)
+	// GetHeadToHeadTotals counts the pair's games and current streak without
+	// loading them. LongestStreak and Recent are left empty.
+	GetHeadToHeadTotals(a, b string) (*HeadToHead, error)
+
+	// GetStandings returns unranked totals for every human player over the
+	// games finished since `since` (zero means all time).
+	GetStandings(since time.Time) ([]LeaderboardEntry, error)
+
+	// Snapshots of games in progress, restored at startup. A finished game's
+	// snapshot is deleted.
+	SaveLiveGame(snap *game.Snapshot) error
+	DeleteLiveGame(gameID string) error
+	ListLiveGames() ([]*game.Snapshot, error)
+
+	// Transactional outbox for analytics events
+	AddOutbox(events ...OutboxEvent) error
+	ClaimOutbox(limit int, lease time.Duration) ([]OutboxEvent, error)
+	MarkOutboxSent(ids ...int64) error
+	MarkOutboxFailed(id int64, cause error, retryAt time.Time) error
+	PruneOutbox(sentBefore time.Time) (int64, error)
+
+	// Post-game reports
+	SaveReport(rep *game.Report) error
+	GetReport(gameID string) (*game.Report, error)
+
+	Close() error
+}
+
+// GameRecord is a finished game. Player1 moved first.
+type GameRecord struct {
+	ID           string    `json:"id"`
+	Player1      string    `json:"player1"`
+	Player2      string    `json:"player2"`
+	Player1IsBot bool      `json:"player1IsBot"`
+	Player2IsBot bool      `json:"player2IsBot"`
+	Winner       string    `json:"winner"` // username or "draw"
+	FinishReason string    `json:"finishReason"`
+	Rated        bool      `json:"rated"`
+	Imported     bool      `json:"imported"`
+	MoveCount    int       `json:"moveCount"`
+	DurationMs   int64     `json:"durationMs"`
+	CreatedAt    time.Time `json:"createdAt"`
+	FinishedAt   time.Time `json:"finishedAt"`
+}
+
+type MoveRecord struct {
+	Ply      int       `json:"ply"`
+	Player   string    `json:"player"` // username
+	Color    int       `json:"color"`
+	Column   int       `json:"column"`
+	Row      int       `json:"row"`
+	PlayedAt time.Time `json:"playedAt"`
+}
+
+type PlayerRecord struct {
+	Username      string    `json:"username"`
+	IsBot         bool      `json:"isBot"`
+	Rating        int       `json:"rating"`
+	CurrentStreak int       `json:"currentStreak"`
+	BestStreak    int       `json:"bestStreak"`
+	FirstSeenAt   time.Time `json:"firstSeenAt"`
+	LastSeenAt    time.Time `json:"lastSeenAt"`
+}
+
+// GameFilter selects games for ListGames. Zero values mean "no filter".
+type GameFilter struct {
+	Player   string
+	Opponent string // with Player, only games between the two
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	Played   bool // only games played here, not imported
+}
+
+// LeaderboardEntry is one player's standing over a period. BestStreak is the
+// longest win streak inside the period.
+type LeaderboardEntry struct {
+	Rank       int     `json:"rank"`
+	Username   string  `json:"username"`
+	Rating     int     `json:"rating"`
+	Games      int     `json:"games"`
+	TotalWins  int     `json:"total_wins"`
+	Draws      int     `json:"draws"`
+	Losses     int     `json:"losses"`
+	WinRate    float64 `json:"win_rate"`
+	BestStreak int     `json:"best_streak"`
+}
+
+var Repo Store
+
+// InitDB picks the backend from DB_DRIVER (postgres, sqlite or memory).
+// Without DB_DRIVER, a DATABASE_URL means Postgres and no URL means memory.
+// A configured database that fails to open is fatal: quietly keeping games
+// in memory would lose them on the next restart.
+func InitDB() {
+	driver, url := os.Getenv("DB_DRIVER"), os.Getenv("DATABASE_URL")
+	store, err := open(driver, url)
+	if err != nil {
+		log.Fatalf("[DB ERROR] Database unavailable: %v", err)
+	}
+	Repo = Instrument(store)
+}
+
+// OpenRollups opens the aggregate store for cmd/consumer, choosing the
+// backend like InitDB. It may share the game server's database.
+func OpenRollups(driver, url string) (RollupStore, error) {
+	return open(driver, url)
+}
+
+// backend is what every store implements.
+type backend interface {
+	Store
+	RollupStore
+}
+
+func open(driver, url string) (backend, error) {
+	if driver == "" {
+		driver = "memory"
+		if url != "" {
+			driver = DialectPostgres
+		}
+	}
+
+	switch driver {
+	case "memory":
+		log.Println("[DB] Using in-memory store, history is lost on restart")
+		return NewMemoryStore(), nil
+	case DialectPostgres:
+		return OpenPostgres(url)
+	case DialectSQLite:
+		if url == "" {
+			url = "fourinrow.db"
+		}
+		return OpenSQLite(url)
+	default:
+		log.Fatalf("[DB ERROR] Unknown DB_DRIVER %q", driver)
+		return nil, nil
+	}
+}
+
+// migrate brings a SQL database's schema up to date. With DB_AUTO_MIGRATE=false
+// the schema is left to `go run ./cmd/migrate up` and we only check that we can
+// run against it. A schema newer than the binary is fatal either way.
+func migrate(conn *sql.DB, dialect string) error {
+	migrator, err := NewMigrator(conn, dialect)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if os.Getenv("DB_AUTO_MIGRATE") == "false" {
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if version > migrator.Latest() {
+			log.Fatalf("[DB ERROR] %v (database %d, binary %d)", ErrSchemaTooNew, version, migrator.Latest())
+		}
+		if version < migrator.Latest() {
+			log.Printf("[DB WARNING] Schema version %d is behind %d, run cmd/migrate", version, migrator.Latest())
+		}
+		return nil
+	}
+
+	applied, err := migrator.Up(ctx, 0)
+	if errors.Is(err, ErrSchemaTooNew) {
+		log.Fatalf("[DB ERROR] %v", err)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		log.Printf("[DB] Applied migrations %v", applied)
+	}
+	return nil
+}
+
+// gameRecord builds the stored form of a finished game.
+func gameRecord(g *game.Game, finished time.Time) (GameRecord, *game.Player, *game.Player) {
+	if !g.FinishedAt.IsZero() {
+		finished = g.FinishedAt
+	}
+	p1, p2, winner := gameParticipants(g)
+	rec := GameRecord{
+		ID: g.ID, Player1: username(p1), Player2: username(p2),
+		Player1IsBot: p1 != nil && p1.IsBot, Player2IsBot: p2 != nil && p2.IsBot,
+		Winner: winner, FinishReason: g.FinishReason, Rated: g.Rated,
+		MoveCount: len(g.Moves), CreatedAt: g.CreatedAt, FinishedAt: finished,
+	}
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = finished
+	}
+	rec.DurationMs = finished.Sub(rec.CreatedAt).Milliseconds()
+	return rec, p1, p2
+}
+
+func username(p *game.Player) string {
+	if p == nil {
+		return ""
+	}
+	return p.Username
+}
+
+// gameParticipants returns the usernames by color and the winner's username.
+func gameParticipants(g *game.Game) (p1, p2 *game.Player, winner string) {
+	for _, p := range g.Players {
+		if p.Color == 1 {
+			p1 = p
+		} else {
+			p2 = p
+		}
+	}
+
+	// Winner holds a player ID (or "draw"); store the username instead
+	winner = g.Winner
+	for _, p := range g.Players {
+		if p.ID == g.Winner {
+			winner = p.Username
+			break
+		}
+	}
+	return p1, p2, winner
+}
+
+// moveRecords converts a game's moves to records keyed by username.
+func moveRecords(g *game.Game) []MoveRecord {
+	names := make(map[string]string, len(g.Players))
+	for _, p := range g.Players {
+		names[p.ID] = p.Username
+	}
+
+	res := make([]MoveRecord, len(g.Moves))
+	for i, m := range g.Moves {
+		res[i] = MoveRecord{
+			Ply: i + 1, Player: names[m.PlayerID], Color: m.Color,
+			Column: m.Column, Row: m.Row, PlayedAt: m.At,
+		}
+	}
+	return res
+}
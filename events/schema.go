@@ -0,0 +1,196 @@
+package events
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+)
+
+// SchemaVersion is written into every event.
+//
+//	0  before versioning: game_started and game_finished only, with a bare
+//	   string payload ("PvP"/"PvE", or the winner's player ID)
+//	1  typed payloads, usernames, the full event catalogue
+//	2  event_id, so consumers can drop redelivered events
+const SchemaVersion = 2
+
+var (
+	ErrUnknownType        = errors.New("unknown event type")
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+)
+
+// Event is one analytics event. Payload holds the value of the type's
+// payload struct (not a pointer), e.g. MoveMadePayload for move_made.
+type Event struct {
+	SchemaVersion int    `json:"schema_version"`
+	ID            string `json:"event_id"`
+	Type          string `json:"type"`
+	GameID        string `json:"game_id"`
+	PlayerID      string `json:"player_id"`
+	Username      string `json:"username,omitempty"`
+	Timestamp     int64  `json:"timestamp"` // Unix seconds
+	Payload       any    `json:"payload"`
+}
+
+// Key is the partition key: the game, or the player for matchmaking events
+// that have no game yet.
+func (e Event) Key() string {
+	if e.GameID != "" {
+		return e.GameID
+	}
+	return e.Username
+}
+
+// payloads maps each event type to its payload struct.
+var payloads = map[string]reflect.Type{
+	GameStarted:           reflect.TypeFor[GameStartedPayload](),
+	GameFinished:          reflect.TypeFor[GameFinishedPayload](),
+	MoveMade:              reflect.TypeFor[MoveMadePayload](),
+	PlayerDisconnected:    reflect.TypeFor[PlayerDisconnectedPayload](),
+	PlayerReconnected:     reflect.TypeFor[PlayerReconnectedPayload](),
+	MatchmakingJoined:     reflect.TypeFor[MatchmakingJoinedPayload](),
+	MatchmakingMatched:    reflect.TypeFor[MatchmakingMatchedPayload](),
+	MatchmakingTimeoutBot: reflect.TypeFor[MatchmakingTimeoutBotPayload](),
+	GameAbandoned:         reflect.TypeFor[GameAbandonedPayload](),
+	Draw:                  reflect.TypeFor[DrawPayload](),
+}
+
+// NewID returns a fresh event ID.
+func NewID() string {
+	return uuid.NewString()
+}
+
+// Types lists the known event types.
+func Types() []string {
+	types := make([]string, 0, len(payloads))
+	for t := range payloads {
+		types = append(types, t)
+	}
+	return types
+}
+
+// Marshal stamps the current schema version on the event, gives it an ID if
+// it has none, validates it and encodes it. Events are marshalled once, into
+// the outbox or the producer, so retries carry the same ID.
+func Marshal(e Event) ([]byte, error) {
+	e.SchemaVersion = SchemaVersion
+	if e.ID == "" {
+		e.ID = NewID()
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(e)
+}
+
+// wire is Event with the payload left undecoded.
+type wire struct {
+	SchemaVersion int             `json:"schema_version"`
+	ID            string          `json:"event_id"`
+	Type          string          `json:"type"`
+	GameID        string          `json:"game_id"`
+	PlayerID      string          `json:"player_id"`
+	Username      string          `json:"username"`
+	Timestamp     int64           `json:"timestamp"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Decode parses and validates an event of any supported version, upgrading
+// old events to the current schema. Unknown fields, types and versions are
+// errors.
+func Decode(data []byte) (Event, error) {
+	var w wire
+	if err := strict(data, &w); err != nil {
+		return Event{}, fmt.Errorf("decode event: %w", err)
+	}
+	if w.SchemaVersion < 0 || w.SchemaVersion > SchemaVersion {
+		return Event{}, fmt.Errorf("%w %d", ErrUnsupportedVersion, w.SchemaVersion)
+	}
+	typ, ok := payloads[w.Type]
+	if !ok {
+		return Event{}, fmt.Errorf("%w %q", ErrUnknownType, w.Type)
+	}
+
+	e := Event{
+		SchemaVersion: w.SchemaVersion,
+		ID:            w.ID,
+		Type:          w.Type,
+		GameID:        w.GameID,
+		PlayerID:      w.PlayerID,
+		Username:      w.Username,
+		Timestamp:     w.Timestamp,
+	}
+	if legacy, ok := upgradeV0(&e, w.Payload); ok {
+		e.Payload = legacy
+	} else {
+		p := reflect.New(typ)
+		if err := strict(w.Payload, p.Interface()); err != nil {
+			return Event{}, fmt.Errorf("decode %s payload: %w", w.Type, err)
+		}
+		e.Payload = p.Elem().Interface()
+	}
+	if e.ID == "" && w.SchemaVersion < 2 {
+		e.ID = legacyID(data)
+	}
+	e.SchemaVersion = SchemaVersion
+
+	if err := e.Validate(); err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+func strict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("trailing data after event")
+	}
+	return nil
+}
+
+// upgradeV0 converts a version 0 event's string payload. Version 0 events
+// written after typed payloads arrived (but before versioning) have an
+// object payload and decode as version 1.
+func upgradeV0(e *Event, raw json.RawMessage) (any, bool) {
+	if e.SchemaVersion != 0 {
+		return nil, false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return nil, false
+	}
+
+	switch e.Type {
+	case GameStarted:
+		return GameStartedPayload{Mode: s}, true
+	case GameFinished:
+		// The payload was the winner's player ID, or "draw"
+		p := GameFinishedPayload{Result: ResultDraw}
+		if s != "" && s != "draw" {
+			p.Result = ResultWin
+			p.Winner = &PlayerRef{PlayerID: s, Bot: s == "cpu"}
+			e.PlayerID = s
+		} else {
+			e.PlayerID = ""
+		}
+		return p, true
+	}
+	return nil, false
+}
+
+// legacyID names an event from before event IDs by its content, so a
+// redelivered copy still gets the same ID.
+func legacyID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "legacy-" + hex.EncodeToString(sum[:16])
+}
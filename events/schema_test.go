@@ -0,0 +1,103 @@
+package events_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fourinrow/events"
+)
+
+var update = flag.Bool("update", false, "rewrite the .golden fixtures from the current decoder")
+
+// TestFixtures proves that events written by every schema version still
+// decode:
+//
+//	fixtures/valid/*.json    must decode; re-encoding gives the file itself,
+//	                         or NAME.golden when there is one (used for
+//	                         upgraded old versions)
+//	fixtures/invalid/*.json  must be rejected
+//
+// Every event type needs a valid fixture at the current version.
+func TestFixtures(t *testing.T) {
+	covered := map[string]bool{}
+	valid, _ := filepath.Glob(filepath.Join("fixtures", "valid", "*.json"))
+	for _, path := range valid {
+		t.Run("valid/"+filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := events.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := events.Marshal(e)
+			if err != nil {
+				t.Fatalf("re-encode: %v", err)
+			}
+
+			golden := strings.TrimSuffix(path, ".json") + ".golden"
+			want := data
+			if g, err := os.ReadFile(golden); err == nil {
+				want = g
+			}
+			if *update && !bytes.Equal(compact(want), out) && !bytes.Equal(compact(data), out) {
+				if err := os.WriteFile(golden, indent(out), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				t.Logf("updated %s", golden)
+				want = out
+			}
+			if !bytes.Equal(compact(want), out) {
+				t.Fatalf("re-encoded as\n\t%s\nwant\n\t%s", out, compact(want))
+			}
+
+			var version struct {
+				SchemaVersion int `json:"schema_version"`
+			}
+			json.Unmarshal(data, &version)
+			if version.SchemaVersion == events.SchemaVersion {
+				covered[e.Type] = true
+			}
+		})
+	}
+
+	invalid, _ := filepath.Glob(filepath.Join("fixtures", "invalid", "*.json"))
+	for _, path := range invalid {
+		t.Run("invalid/"+filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := events.Decode(data); err == nil {
+				t.Fatal("decoded, want an error")
+			}
+		})
+	}
+
+	for _, typ := range events.Types() {
+		if !covered[typ] {
+			t.Errorf("no valid fixture for %s at schema version %d", typ, events.SchemaVersion)
+		}
+	}
+}
+
+func compact(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+func indent(data []byte) []byte {
+	var buf bytes.Buffer
+	json.Indent(&buf, data, "", "  ")
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
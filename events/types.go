@@ -0,0 +1,110 @@
+// Package events is the schema of the analytics events the server produces
+// and the consumer reads. Both sides go through Marshal and Decode, so a
+// change here is a change to the wire format. Decode rejects unknown fields
+// and types, so any new field or event type needs a SchemaVersion bump, and
+// the consumer must be deployed before the server that produces it. Never
+// repurpose an existing field.
+package events
+
+// Event types. Every event names the game it belongs to (except
+// matchmaking_joined, which comes before one) and, when one player is
+// involved, that player's PlayerID and Username.
+const (
+	GameStarted           = "game_started"
+	GameFinished          = "game_finished"
+	MoveMade              = "move_made"
+	PlayerDisconnected    = "player_disconnected"
+	PlayerReconnected     = "player_reconnected"
+	MatchmakingJoined     = "matchmaking_joined"
+	MatchmakingMatched    = "matchmaking_matched"
+	MatchmakingTimeoutBot = "matchmaking_timeout_bot"
+	GameAbandoned         = "game_abandoned" // alongside game_finished when a player never came back
+	Draw                  = "draw"           // alongside game_finished when nobody won
+)
+
+// Game modes
+const (
+	ModePvP = "PvP"
+	ModePvE = "PvE"
+)
+
+// PlayerRef identifies a player inside a payload. PlayerID is the in-game
+// ID ("cpu" for the bot); Username is stable across games.
+type PlayerRef struct {
+	PlayerID string `json:"player_id"`
+	Username string `json:"username"`
+	Bot      bool   `json:"bot,omitempty"`
+}
+
+type GameStartedPayload struct {
+	Mode    string      `json:"mode"`
+	Rated   bool        `json:"rated"`
+	Players []PlayerRef `json:"players,omitempty"` // in colour order, red first; missing before version 1
+}
+
+// Results of a finished game
+const (
+	ResultWin  = "win"
+	ResultDraw = "draw"
+)
+
+// GameFinishedPayload goes with game_finished. The event's PlayerID and
+// Username are the winner's, and empty for a draw.
+type GameFinishedPayload struct {
+	Mode       string     `json:"mode"`
+	Result     string     `json:"result"` // ResultWin or ResultDraw
+	Reason     string     `json:"reason"` // game.Finish* constant
+	Winner     *PlayerRef `json:"winner,omitempty"`
+	Moves      int        `json:"moves"`
+	DurationMs int64      `json:"duration_ms"`
+	Rated      bool       `json:"rated"`
+}
+
+type MoveMadePayload struct {
+	Column  int   `json:"column"`
+	Row     int   `json:"row"`
+	Ply     int   `json:"ply"` // 1 for the first move of the game
+	Color   int   `json:"color"`
+	ThinkMs int64 `json:"think_ms"` // since the previous move, or the start
+	Bot     bool  `json:"bot,omitempty"`
+}
+
+type PlayerDisconnectedPayload struct {
+	Ply     int   `json:"ply"`      // moves played so far
+	GraceMs int64 `json:"grace_ms"` // how long they have to come back
+}
+
+type PlayerReconnectedPayload struct {
+	AwayMs int64  `json:"away_ms"`
+	Node   string `json:"node"`
+}
+
+type MatchmakingJoinedPayload struct {
+	Node string `json:"node"`
+}
+
+// MatchmakingMatchedPayload is sent once per matched player.
+type MatchmakingMatchedPayload struct {
+	Opponent PlayerRef `json:"opponent"`
+	WaitMs   int64     `json:"wait_ms"`
+	Remote   bool      `json:"remote,omitempty"` // opponent is connected to another node
+}
+
+type MatchmakingTimeoutBotPayload struct {
+	WaitMs     int64  `json:"wait_ms"`
+	BotProfile string `json:"bot_profile"`
+}
+
+// GameAbandonedPayload lists the players who were gone when the game ended.
+type GameAbandonedPayload struct {
+	AbandonedBy []PlayerRef `json:"abandoned_by"`
+	Reason      string      `json:"reason"`
+	Ply         int         `json:"ply"`
+}
+
+type DrawPayload struct {
+	Reason  string      `json:"reason"`
+	Ply     int         `json:"ply"`
+	Mode    string      `json:"mode"`
+	Players []PlayerRef `json:"players"`
+}
@@ -0,0 +1,125 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var ErrInvalid = errors.New("invalid event")
+
+// playerEvents are about one player, who must be named.
+var playerEvents = map[string]bool{
+	MoveMade:              true,
+	PlayerDisconnected:    true,
+	PlayerReconnected:     true,
+	MatchmakingJoined:     true,
+	MatchmakingMatched:    true,
+	MatchmakingTimeoutBot: true,
+}
+
+// Validate checks the envelope and the payload's invariants. It accepts
+// upgraded version 0 events, which lack usernames and player lists.
+func (e Event) Validate() error {
+	typ, ok := payloads[e.Type]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownType, e.Type)
+	}
+	if got := reflect.TypeOf(e.Payload); got != typ {
+		return e.invalid("payload is %v, want %v", got, typ)
+	}
+	if e.ID == "" {
+		return e.invalid("missing event_id")
+	}
+	if e.Timestamp <= 0 {
+		return e.invalid("missing timestamp")
+	}
+	if e.GameID == "" && e.Type != MatchmakingJoined {
+		return e.invalid("missing game_id")
+	}
+	if playerEvents[e.Type] && (e.PlayerID == "" || e.Username == "") {
+		return e.invalid("missing player_id or username")
+	}
+
+	switch p := e.Payload.(type) {
+	case GameStartedPayload:
+		if !validMode(p.Mode) {
+			return e.invalid("mode %q", p.Mode)
+		}
+		if len(p.Players) != 0 && len(p.Players) != 2 {
+			return e.invalid("%d players", len(p.Players))
+		}
+		for _, ref := range p.Players {
+			if ref.PlayerID == "" || ref.Username == "" {
+				return e.invalid("player without player_id or username")
+			}
+		}
+
+	case GameFinishedPayload:
+		if p.Mode != "" && !validMode(p.Mode) {
+			return e.invalid("mode %q", p.Mode)
+		}
+		switch p.Result {
+		case ResultWin:
+			if p.Winner == nil || p.Winner.PlayerID == "" || p.Winner.PlayerID != e.PlayerID {
+				return e.invalid("win without a winner matching player_id")
+			}
+		case ResultDraw:
+			if p.Winner != nil || e.PlayerID != "" {
+				return e.invalid("draw with a winner")
+			}
+		default:
+			return e.invalid("result %q", p.Result)
+		}
+		if p.Moves < 0 || p.DurationMs < 0 {
+			return e.invalid("negative moves or duration")
+		}
+
+	case MoveMadePayload:
+		if p.Column < 0 || p.Column > 6 || p.Row < 0 || p.Row > 5 {
+			return e.invalid("square %d,%d is off the board", p.Column, p.Row)
+		}
+		if p.Ply < 1 || (p.Color != 1 && p.Color != 2) || p.ThinkMs < 0 {
+			return e.invalid("ply %d, color %d, think_ms %d", p.Ply, p.Color, p.ThinkMs)
+		}
+
+	case PlayerDisconnectedPayload:
+		if p.Ply < 0 || p.GraceMs <= 0 {
+			return e.invalid("ply %d, grace_ms %d", p.Ply, p.GraceMs)
+		}
+
+	case PlayerReconnectedPayload:
+		if p.AwayMs < 0 {
+			return e.invalid("negative away_ms")
+		}
+
+	case MatchmakingMatchedPayload:
+		if p.Opponent.Username == "" || p.WaitMs < 0 {
+			return e.invalid("opponent %q, wait_ms %d", p.Opponent.Username, p.WaitMs)
+		}
+
+	case MatchmakingTimeoutBotPayload:
+		if p.BotProfile == "" || p.WaitMs < 0 {
+			return e.invalid("bot_profile %q, wait_ms %d", p.BotProfile, p.WaitMs)
+		}
+
+	case GameAbandonedPayload:
+		if p.Reason == "" {
+			return e.invalid("missing reason")
+		}
+
+	case DrawPayload:
+		if p.Reason == "" {
+			return e.invalid("missing reason")
+		}
+	}
+	return nil
+}
+
+func (e Event) invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s: %s", ErrInvalid, e.Type, fmt.Sprintf(format, args...))
+}
+
+func validMode(m string) bool {
+	return m == ModePvP || m == ModePvE
+}
@@ -6,8 +6,17 @@ import (
 	"fourinrow/game"
 )
 
+// SearchDepth is how many plies GetBestMove looks ahead once there is no
+// immediate win or block. 0 keeps the old centre-first placement.
+var SearchDepth = 8
+
 func GetBestMove(g *game.Game, botColor int) (int, error) {
-	board := g.Board
+	return bestMove(g.Board, botColor, SearchDepth, TableForGame(g.ID))
+}
+
+// bestMove takes an immediate win, then blocks an immediate loss, then
+// searches `depth` plies (or falls back to centre-first when depth is 0).
+func bestMove(board [6][7]int, botColor, depth int, tt *Table) (int, error) {
 	enemy := 1
 	if botColor == 1 {
 		enemy = 2
@@ -30,6 +39,13 @@ func GetBestMove(g *game.Game, botColor int) (int, error) {
 		}
 	}
 
+	if depth > 0 {
+		res := Search(board, botColor, SearchOptions{Depth: depth, Table: tt})
+		if res.Move >= 0 {
+			return res.Move, nil
+		}
+	}
+
 	order := []int{3, 2, 4, 1, 5, 0, 6}
 	for _, c := range order {
 		if board[0][c] == 0 {
@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"fourinrow/game"
+)
+
+// Engine is a named move picker. Engines built by NewEngine own their
+// transposition table, so two engines never see each other's search results.
+type Engine interface {
+	Name() string
+	BestMove(g *game.Game, color int) (int, error)
+}
+
+type searchEngine struct {
+	name  string
+	depth int
+	tt    *Table
+}
+
+func (e *searchEngine) Name() string { return e.name }
+
+func (e *searchEngine) BestMove(g *game.Game, color int) (int, error) {
+	return bestMove(g.Board, color, e.depth, e.tt)
+}
+
+// EngineNames lists the names accepted by NewEngine.
+func EngineNames() []string {
+	names := []string{"heuristic", "default", "search-<depth>"}
+	for key := range Profiles {
+		names = append(names, "profile-"+key)
+	}
+	return names
+}
+
+// NewEngine builds an engine by name:
+//
+//	heuristic       win / block / centre-first (the original bot)
+//	default         what live games use (SearchDepth plies)
+//	search-<depth>  alpha-beta search to a fixed depth, e.g. search-10
+//	profile-<key>   a bot personality from Profiles, mistakes included
+func NewEngine(name string) (Engine, error) {
+	switch {
+	case name == "heuristic":
+		return &searchEngine{name: name}, nil
+	case name == "default":
+		return &searchEngine{name: name, depth: SearchDepth, tt: NewTable(1 << 18)}, nil
+	case strings.HasPrefix(name, "search-"):
+		depth, err := strconv.Atoi(strings.TrimPrefix(name, "search-"))
+		if err != nil || depth < 1 || depth > 42 {
+			return nil, fmt.Errorf("invalid search depth in %q", name)
+		}
+		return &searchEngine{name: name, depth: depth, tt: NewTable(1 << 18)}, nil
+	case strings.HasPrefix(name, "profile-"):
+		if p, ok := Profiles[strings.TrimPrefix(name, "profile-")]; ok {
+			return &profileEngine{p: p, tt: NewTable(1 << 18)}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown engine %q (known: %s)", name, strings.Join(EngineNames(), ", "))
+}
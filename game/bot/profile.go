@@ -0,0 +1,249 @@
+package bot
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"fourinrow/game"
+)
+
+// Style biases a profile's choice between moves the engine rates similarly.
+type Style string
+
+const (
+	StyleBalanced   Style = "balanced"
+	StyleAggressive Style = "aggressive" // builds its own lines
+	StyleDefensive  Style = "defensive"  // sits on the opponent's lines
+	StyleCentre     Style = "centre"     // hugs the middle columns
+)
+
+// Profile is a bot personality.
+type Profile struct {
+	Key         string `json:"key"`
+	DisplayName string `json:"displayName"`
+	Avatar      string `json:"avatar"` // asset key for the client
+
+	Depth     int     `json:"depth"`     // search depth in plies
+	ErrorRate float64 `json:"errorRate"` // chance of playing something other than its favourite move
+	Style     Style   `json:"style"`
+	// MissesDeepThreats lets mistakes walk into forced losses. Without it the
+	// bot only errs between moves that do not lose by force.
+	MissesDeepThreats bool `json:"missesDeepThreats"`
+
+	MinThink time.Duration `json:"-"`
+	MaxThink time.Duration `json:"-"`
+}
+
+// Profiles available to StartBotGame, by key.
+var Profiles = map[string]*Profile{
+	"rookie": {
+		Key: "rookie", DisplayName: "Rookie Robin", Avatar: "bot-rookie",
+		Depth: 2, ErrorRate: 0.35, Style: StyleCentre, MissesDeepThreats: true,
+		MinThink: 400 * time.Millisecond, MaxThink: 1200 * time.Millisecond,
+	},
+	"blaze": {
+		Key: "blaze", DisplayName: "Blaze", Avatar: "bot-blaze",
+		Depth: 6, ErrorRate: 0.12, Style: StyleAggressive, MissesDeepThreats: true,
+		MinThink: 300 * time.Millisecond, MaxThink: 1000 * time.Millisecond,
+	},
+	"bastion": {
+		Key: "bastion", DisplayName: "Bastion", Avatar: "bot-bastion",
+		Depth: 6, ErrorRate: 0.10, Style: StyleDefensive,
+		MinThink: 500 * time.Millisecond, MaxThink: 1500 * time.Millisecond,
+	},
+	"professor": {
+		Key: "professor", DisplayName: "Professor Hoot", Avatar: "bot-professor",
+		Depth: 10, ErrorRate: 0.02, Style: StyleBalanced,
+		MinThink: 700 * time.Millisecond, MaxThink: 2500 * time.Millisecond,
+	},
+}
+
+// DefaultProfile is used when a game refers to an unknown profile key.
+var DefaultProfile = Profiles["bastion"]
+
+// UsernamePrefix starts every bot's username. Humans can't join under it, so
+// a bot's games and stats never mix with a player who picked its display name.
+const UsernamePrefix = "bot:"
+
+// Username is the reserved name the profile plays and is recorded under. The
+// display name is only shown to the opponent.
+func (p *Profile) Username() string {
+	return UsernamePrefix + p.Key
+}
+
+// IsReservedUsername reports whether a human may not use the name.
+func IsReservedUsername(name string) bool {
+	return strings.HasPrefix(name, UsernamePrefix)
+}
+
+// ProfileByKey returns the named profile or DefaultProfile.
+func ProfileByKey(key string) *Profile {
+	if p, ok := Profiles[key]; ok {
+		return p
+	}
+	return DefaultProfile
+}
+
+// RandomProfile picks an opponent for a new bot game.
+func RandomProfile() *Profile {
+	keys := make([]string, 0, len(Profiles))
+	for k := range Profiles {
+		keys = append(keys, k)
+	}
+	return Profiles[keys[rand.Intn(len(keys))]]
+}
+
+// Decision is a profile's move plus how long it should appear to think.
+type Decision struct {
+	Column int
+	Think  time.Duration
+}
+
+// Decide picks a move for the profile in a live game.
+func (p *Profile) Decide(g *game.Game, color int) (Decision, error) {
+	return p.decide(g.Board, color, TableForGame(g.ID))
+}
+
+func (p *Profile) decide(board [6][7]int, color int, tt *Table) (Decision, error) {
+	res := Search(board, color, SearchOptions{Depth: p.Depth, Table: tt, AllScores: true})
+	if res.Move < 0 {
+		return Decision{}, errors.New("no valid moves")
+	}
+
+	// 1. Rank moves by engine score plus the profile's style bias
+	best, bestVal := -1, 0
+	var legal []int
+	for c := 0; c < 7; c++ {
+		if res.Scores[c] == nil {
+			continue
+		}
+		legal = append(legal, c)
+		v := *res.Scores[c]
+		if v > -WinScore+winMargin && v < WinScore-winMargin {
+			v += p.styleBonus(board, c, color)
+		}
+		if best < 0 || v > bestVal {
+			best, bestVal = c, v
+		}
+	}
+
+	// 2. Sometimes play something else, like a human would
+	col := best
+	if rand.Float64() < p.ErrorRate {
+		var options []int
+		for _, c := range legal {
+			if c == best {
+				continue
+			}
+			if !p.MissesDeepThreats && *res.Scores[c] < -WinScore+winMargin {
+				continue
+			}
+			options = append(options, c)
+		}
+		if len(options) > 0 {
+			col = options[rand.Intn(len(options))]
+		}
+	}
+
+	return Decision{Column: col, Think: p.thinkTime(res, len(legal))}, nil
+}
+
+// styleBonus rewards the cells a style likes. Values are small compared to
+// the evaluation so style only breaks near-ties.
+func (p *Profile) styleBonus(board [6][7]int, col, color int) int {
+	row := -1
+	for r := 5; r >= 0; r-- {
+		if board[r][col] == 0 {
+			row = r
+			break
+		}
+	}
+	if row < 0 {
+		return 0
+	}
+
+	switch p.Style {
+	case StyleCentre:
+		d := col - 3
+		if d < 0 {
+			d = -d
+		}
+		return (3 - d) * 2
+	case StyleAggressive, StyleDefensive:
+		bonus := 0
+		for _, w := range windows {
+			if !windowHas(w, row, col) {
+				continue
+			}
+			mine, theirs := 0, 0
+			for _, cell := range w {
+				switch board[cell[0]][cell[1]] {
+				case 0:
+				case color:
+					mine++
+				default:
+					theirs++
+				}
+			}
+			if p.Style == StyleAggressive && theirs == 0 {
+				bonus += mine
+			}
+			if p.Style == StyleDefensive && mine == 0 {
+				bonus += theirs
+			}
+		}
+		return bonus
+	}
+	return 0
+}
+
+func windowHas(w [4][2]int, r, c int) bool {
+	for _, cell := range w {
+		if cell[0] == r && cell[1] == c {
+			return true
+		}
+	}
+	return false
+}
+
+// thinkTime is longer when several moves score close to the best one (a hard
+// choice) and short when the position is forced or has one obvious move.
+func (p *Profile) thinkTime(res Result, legal int) time.Duration {
+	if p.MaxThink <= p.MinThink {
+		return p.MinThink
+	}
+
+	difficulty := 0.0
+	forced := res.Score > WinScore-winMargin || res.Score < -WinScore+winMargin
+	if !forced && legal > 1 {
+		near := 0
+		for _, s := range res.Scores {
+			if s != nil && res.Score-*s <= 5 {
+				near++
+			}
+		}
+		difficulty = float64(near-1) / float64(legal-1)
+	}
+
+	span := float64(p.MaxThink - p.MinThink)
+	jitter := (rand.Float64() - 0.5) * 0.2 * span
+	think := p.MinThink + time.Duration(difficulty*span*0.8+jitter)
+	if think < p.MinThink {
+		think = p.MinThink
+	}
+	return think
+}
+
+type profileEngine struct {
+	p  *Profile
+	tt *Table
+}
+
+func (e *profileEngine) Name() string { return "profile-" + e.p.Key }
+
+func (e *profileEngine) BestMove(g *game.Game, color int) (int, error) {
+	d, err := e.p.decide(g.Board, color, e.tt)
+	return d.Column, err
+}
@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"math"
+	"time"
+
+	"fourinrow/game"
+)
+
+// Review settings: each position before a move gets its own bounded search.
+var (
+	ReviewDepth     = 12
+	ReviewMoveLimit = 250 * time.Millisecond
+)
+
+// Loss thresholds (in expected result, 0..1) for each classification.
+const (
+	goodLoss       = 0.05
+	inaccuracyLoss = 0.12
+	mistakeLoss    = 0.25
+)
+
+// Review replays a move history and compares every move to the engine's
+// choice in the same position.
+func Review(moves []game.Move) []game.MoveReview {
+	tt := NewTable(1 << 18)
+	var board [6][7]int
+	reviews := make([]game.MoveReview, 0, len(moves))
+
+	for i, m := range moves {
+		res := Search(board, m.Color, SearchOptions{
+			Depth:     ReviewDepth,
+			Deadline:  time.Now().Add(ReviewMoveLimit),
+			Table:     tt,
+			AllScores: true,
+		})
+
+		rv := game.MoveReview{
+			Ply:       i + 1,
+			PlayerID:  m.PlayerID,
+			Color:     m.Color,
+			Column:    m.Column,
+			BestMove:  res.Move,
+			BestScore: res.Score,
+			Score:     res.Score,
+			Quality:   game.QualityBest,
+		}
+		if m.Column >= 0 && m.Column < 7 && res.Scores[m.Column] != nil {
+			rv.Score = *res.Scores[m.Column]
+		}
+		if rv.Score < rv.BestScore {
+			rv.Loss = expected(rv.BestScore) - expected(rv.Score)
+			rv.Quality = classify(rv.Loss)
+		}
+		reviews = append(reviews, rv)
+
+		board[m.Row][m.Column] = m.Color
+	}
+	return reviews
+}
+
+// Accuracy turns a player's move reviews into a 0..100 percentage.
+func Accuracy(reviews []game.MoveReview, color int) float64 {
+	n, total := 0, 0.0
+	for _, r := range reviews {
+		if r.Color != color {
+			continue
+		}
+		n++
+		total += 1 - r.Loss
+	}
+	if n == 0 {
+		return 100
+	}
+	return math.Round(total/float64(n)*1000) / 10
+}
+
+// expected maps an engine score to an expected result between 0 (lost) and
+// 1 (won). Forced results are exact; heuristic scores go through a logistic.
+func expected(score int) float64 {
+	switch {
+	case score > WinScore-winMargin:
+		return 1
+	case score < -WinScore+winMargin:
+		return 0
+	}
+	return 1 / (1 + math.Exp(-float64(score)/20))
+}
+
+func classify(loss float64) string {
+	switch {
+	case loss <= 0:
+		return game.QualityBest
+	case loss < goodLoss:
+		return game.QualityGood
+	case loss < inaccuracyLoss:
+		return game.QualityInaccuracy
+	case loss < mistakeLoss:
+		return game.QualityMistake
+	}
+	return game.QualityBlunder
+}
@@ -0,0 +1,379 @@
+package bot
+
+import (
+	"time"
+)
+
+// Scores are from the point of view of the side to move. A win found `n` plies
+// from the root is worth WinScore-n so faster wins are preferred.
+const (
+	WinScore  = 1000000
+	winMargin = 100 // anything above WinScore-winMargin is a forced result
+)
+
+// Column order used for move ordering: centre columns first.
+var searchOrder = [7]int{3, 2, 4, 1, 5, 0, 6}
+
+// SearchOptions controls a single search.
+type SearchOptions struct {
+	Depth     int       // maximum depth in plies
+	Deadline  time.Time // optional; the last fully completed depth is returned
+	Table     *Table    // optional; a private table is used if nil
+	AllScores bool      // search every root column with a full window so Scores are exact
+}
+
+// Result is the outcome of a search.
+type Result struct {
+	Move   int     `json:"move"`
+	Score  int     `json:"score"`
+	Depth  int     `json:"depth"`
+	Scores [7]*int `json:"scores"` // per column, nil when the column is full
+	PV     []int   `json:"pv"`
+	Nodes  uint64  `json:"nodes"`
+}
+
+// position is the mutable board used inside the search.
+type position struct {
+	board   [6][7]int
+	heights [7]int
+	hash    uint64
+	toMove  int
+	plies   int
+}
+
+func newPosition(board [6][7]int, toMove int) *position {
+	p := &position{board: board, toMove: toMove, hash: Hash(board, toMove)}
+	for c := 0; c < 7; c++ {
+		for r := 5; r >= 0 && board[r][c] != 0; r-- {
+			p.heights[c]++
+			p.plies++
+		}
+	}
+	return p
+}
+
+func (p *position) canPlay(c int) bool { return p.heights[c] < 6 }
+
+func (p *position) play(c int) {
+	r := 5 - p.heights[c]
+	p.board[r][c] = p.toMove
+	p.hash ^= zobristCells[r][c][p.toMove] ^ zobristSide
+	p.heights[c]++
+	p.plies++
+	p.toMove = 3 - p.toMove
+}
+
+func (p *position) undo(c int) {
+	p.heights[c]--
+	p.plies--
+	p.toMove = 3 - p.toMove
+	r := 5 - p.heights[c]
+	p.board[r][c] = 0
+	p.hash ^= zobristCells[r][c][p.toMove] ^ zobristSide
+}
+
+// wins reports whether dropping into column c wins for the side to move.
+func (p *position) wins(c int) bool {
+	r := 5 - p.heights[c]
+	b := p.board
+	b[r][c] = p.toMove
+	return check(b, r, c, p.toMove)
+}
+
+type searcher struct {
+	tt       *Table
+	deadline time.Time
+	nodes    uint64
+	aborted  bool
+}
+
+// Search runs an iterative-deepening alpha-beta search for `color` to move.
+func Search(board [6][7]int, color int, opts SearchOptions) Result {
+	if opts.Table == nil {
+		opts.Table = NewTable(1 << 16)
+	}
+	if opts.Depth <= 0 {
+		opts.Depth = 1
+	}
+	opts.Table.NewSearch()
+
+	s := &searcher{tt: opts.Table, deadline: opts.Deadline}
+	pos := newPosition(board, color)
+
+	best := Result{Move: -1}
+	for depth := 1; depth <= opts.Depth && depth <= 42-pos.plies; depth++ {
+		res := s.root(pos, depth, opts.AllScores)
+		if s.aborted {
+			break
+		}
+		best = res
+		// A forced result will not change with more depth
+		if res.Score > WinScore-winMargin || res.Score < -WinScore+winMargin {
+			break
+		}
+	}
+
+	best.Nodes = s.nodes
+	if best.Move >= 0 {
+		best.PV = s.principalVariation(newPosition(board, color), best.Move, best.Depth)
+	}
+	return best
+}
+
+// Analyze searches a position for up to `limit` and returns an exact score
+// for every playable column, the best move and its principal variation.
+func Analyze(board [6][7]int, color int, limit time.Duration) Result {
+	return Search(board, color, SearchOptions{
+		Depth:     42,
+		Deadline:  time.Now().Add(limit),
+		AllScores: true,
+	})
+}
+
+func (s *searcher) root(pos *position, depth int, allScores bool) Result {
+	res := Result{Move: -1, Depth: depth}
+	alpha, beta := -WinScore-1, WinScore+1
+
+	for _, c := range s.ordered(pos) {
+		var score int
+		if pos.wins(c) {
+			score = WinScore - 1
+		} else {
+			a := alpha
+			if allScores {
+				a = -WinScore - 1
+			}
+			pos.play(c)
+			score = -s.negamax(pos, depth-1, -beta, -a, 1)
+			pos.undo(c)
+		}
+		if s.aborted {
+			return res
+		}
+
+		v := score
+		res.Scores[c] = &v
+		if res.Move < 0 || score > res.Score {
+			res.Move, res.Score = c, score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+
+	s.tt.Store(pos.hash, depth, res.Score, BoundExact, res.Move)
+	return res
+}
+
+func (s *searcher) negamax(pos *position, depth, alpha, beta, ply int) int {
+	s.nodes++
+	if s.nodes&1023 == 0 && !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		s.aborted = true
+	}
+	if s.aborted {
+		return 0
+	}
+	if pos.plies == 42 {
+		return 0
+	}
+
+	// Immediate win for the side to move
+	for c := 0; c < 7; c++ {
+		if pos.canPlay(c) && pos.wins(c) {
+			return WinScore - ply - 1
+		}
+	}
+	if depth == 0 {
+		return evaluate(pos)
+	}
+
+	origAlpha := alpha
+	ttMove := -1
+	if e, ok := s.tt.Probe(pos.hash); ok {
+		ttMove = int(e.Move)
+		if int(e.Depth) >= depth {
+			score := fromTable(int(e.Score), ply)
+			switch e.Bound {
+			case BoundExact:
+				return score
+			case BoundLower:
+				if score > alpha {
+					alpha = score
+				}
+			case BoundUpper:
+				if score < beta {
+					beta = score
+				}
+			}
+			if alpha >= beta {
+				return score
+			}
+		}
+	}
+
+	best, bestMove := -WinScore-1, -1
+	for _, c := range s.orderedWith(pos, ttMove) {
+		pos.play(c)
+		score := -s.negamax(pos, depth-1, -beta, -alpha, ply+1)
+		pos.undo(c)
+		if s.aborted {
+			return 0
+		}
+
+		if score > best {
+			best, bestMove = score, c
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+
+	bound := BoundExact
+	if best <= origAlpha {
+		bound = BoundUpper
+	} else if best >= beta {
+		bound = BoundLower
+	}
+	s.tt.Store(pos.hash, depth, toTable(best, ply), bound, bestMove)
+	return best
+}
+
+func (s *searcher) ordered(pos *position) []int {
+	return s.orderedWith(pos, -1)
+}
+
+// orderedWith returns the legal columns, the table's best move first and then
+// centre-out.
+func (s *searcher) orderedWith(pos *position, first int) []int {
+	moves := make([]int, 0, 7)
+	if first >= 0 && first < 7 && pos.canPlay(first) {
+		moves = append(moves, first)
+	}
+	for _, c := range searchOrder {
+		if c != first && pos.canPlay(c) {
+			moves = append(moves, c)
+		}
+	}
+	return moves
+}
+
+// principalVariation follows the table's best moves from the root.
+func (s *searcher) principalVariation(pos *position, first, depth int) []int {
+	pv := []int{first}
+	if pos.wins(first) {
+		return pv
+	}
+	pos.play(first)
+	for len(pv) < depth {
+		e, ok := s.tt.Probe(pos.hash)
+		if !ok || e.Move < 0 || !pos.canPlay(int(e.Move)) {
+			break
+		}
+		c := int(e.Move)
+		pv = append(pv, c)
+		if pos.wins(c) {
+			break
+		}
+		pos.play(c)
+	}
+	return pv
+}
+
+// Outcome reads a forced result from a score: 1 if the side to move wins
+// with best play, -1 if it loses, 0 if the search found neither.
+func Outcome(score int) int {
+	switch {
+	case score > WinScore-winMargin:
+		return 1
+	case score < -WinScore+winMargin:
+		return -1
+	}
+	return 0
+}
+
+// Forced-result scores depend on the distance from the root, so they are
+// stored relative to the node and converted back on probe.
+func toTable(score, ply int) int {
+	if score > WinScore-winMargin {
+		return score + ply
+	}
+	if score < -WinScore+winMargin {
+		return score - ply
+	}
+	return score
+}
+
+func fromTable(score, ply int) int {
+	if score > WinScore-winMargin {
+		return score - ply
+	}
+	if score < -WinScore+winMargin {
+		return score + ply
+	}
+	return score
+}
+
+// ---------------------------------------------------------
+// Static evaluation
+// ---------------------------------------------------------
+
+// windows lists every line of four cells on the board.
+var windows [][4][2]int
+
+func init() {
+	dirs := [][2]int{{0, 1}, {1, 0}, {1, 1}, {-1, 1}}
+	for r := 0; r < 6; r++ {
+		for c := 0; c < 7; c++ {
+			for _, d := range dirs {
+				er, ec := r+3*d[0], c+3*d[1]
+				if er < 0 || er >= 6 || ec < 0 || ec >= 7 {
+					continue
+				}
+				var w [4][2]int
+				for i := 0; i < 4; i++ {
+					w[i] = [2]int{r + i*d[0], c + i*d[1]}
+				}
+				windows = append(windows, w)
+			}
+		}
+	}
+}
+
+// evaluate scores open lines and centre control for the side to move.
+func evaluate(pos *position) int {
+	me, them := pos.toMove, 3-pos.toMove
+	score := 0
+	for _, w := range windows {
+		mine, theirs := 0, 0
+		for _, cell := range w {
+			switch pos.board[cell[0]][cell[1]] {
+			case me:
+				mine++
+			case them:
+				theirs++
+			}
+		}
+		switch {
+		case theirs == 0 && mine == 3:
+			score += 5
+		case theirs == 0 && mine == 2:
+			score += 2
+		case mine == 0 && theirs == 3:
+			score -= 5
+		case mine == 0 && theirs == 2:
+			score -= 2
+		}
+	}
+	for r := 0; r < 6; r++ {
+		switch pos.board[r][3] {
+		case me:
+			score += 3
+		case them:
+			score -= 3
+		}
+	}
+	return score
+}
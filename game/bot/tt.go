@@ -0,0 +1,236 @@
+package bot
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Bound tells how a stored score relates to the true minimax value.
+type Bound uint8
+
+const (
+	BoundNone  Bound = iota
+	BoundExact       // score is the exact value
+	BoundLower       // search failed high: value >= score
+	BoundUpper       // search failed low: value <= score
+)
+
+// Entry is one transposition table slot.
+type Entry struct {
+	Key   uint64
+	Score int32
+	Depth int8
+	Bound Bound
+	Move  int8 // best column found, -1 if unknown
+	gen   uint8
+}
+
+// TableStats is a snapshot of the table counters.
+type TableStats struct {
+	Entries  int     `json:"entries"`
+	Probes   uint64  `json:"probes"`
+	Hits     uint64  `json:"hits"`
+	Stores   uint64  `json:"stores"`
+	Replaced uint64  `json:"replaced"`
+	Rejected uint64  `json:"rejected"`
+	HitRate  float64 `json:"hitRate"`
+}
+
+const tableShards = 64
+
+type shard struct {
+	mu    sync.Mutex
+	slots []Entry
+}
+
+// Table is a bounded transposition table. It is split into independently
+// locked shards so several bot games can search through it at the same time.
+type Table struct {
+	shards [tableShards]shard
+	size   int
+	gen    atomic.Uint32
+
+	probes   atomic.Uint64
+	hits     atomic.Uint64
+	stores   atomic.Uint64
+	replaced atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// NewTable creates a table holding roughly `entries` positions.
+func NewTable(entries int) *Table {
+	per := entries / tableShards
+	if per < 1 {
+		per = 1
+	}
+	t := &Table{size: per * tableShards}
+	for i := range t.shards {
+		t.shards[i].slots = make([]Entry, per)
+	}
+	return t
+}
+
+func (t *Table) slot(key uint64) (*shard, int) {
+	s := &t.shards[key%tableShards]
+	return s, int((key / tableShards) % uint64(len(s.slots)))
+}
+
+// NewSearch ages the table so entries from earlier searches are the first to
+// be replaced.
+func (t *Table) NewSearch() {
+	t.gen.Add(1)
+}
+
+// Probe looks up a position by hash.
+func (t *Table) Probe(key uint64) (Entry, bool) {
+	t.probes.Add(1)
+	s, i := t.slot(key)
+	s.mu.Lock()
+	e := s.slots[i]
+	s.mu.Unlock()
+	if e.Bound == BoundNone || e.Key != key {
+		return Entry{}, false
+	}
+	t.hits.Add(1)
+	return e, true
+}
+
+// Store saves a search result. Replacement policy: an empty slot, the same
+// position or an entry from an older search is always overwritten; otherwise
+// the deeper search wins.
+func (t *Table) Store(key uint64, depth int, score int, bound Bound, move int) {
+	gen := uint8(t.gen.Load())
+	s, i := t.slot(key)
+
+	s.mu.Lock()
+	old := &s.slots[i]
+	switch {
+	case old.Bound == BoundNone, old.Key == key:
+	case old.gen != gen || depth >= int(old.Depth):
+		t.replaced.Add(1)
+	default:
+		s.mu.Unlock()
+		t.rejected.Add(1)
+		return
+	}
+	*old = Entry{Key: key, Score: int32(score), Depth: int8(depth), Bound: bound, Move: int8(move), gen: gen}
+	s.mu.Unlock()
+
+	t.stores.Add(1)
+}
+
+// Clear empties the table and resets its counters.
+func (t *Table) Clear() {
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.Lock()
+		for j := range s.slots {
+			s.slots[j] = Entry{}
+		}
+		s.mu.Unlock()
+	}
+	t.probes.Store(0)
+	t.hits.Store(0)
+	t.stores.Store(0)
+	t.replaced.Store(0)
+	t.rejected.Store(0)
+}
+
+// Stats returns the current counters and hit rate.
+func (t *Table) Stats() TableStats {
+	st := TableStats{
+		Entries:  t.size,
+		Probes:   t.probes.Load(),
+		Hits:     t.hits.Load(),
+		Stores:   t.stores.Load(),
+		Replaced: t.replaced.Load(),
+		Rejected: t.rejected.Load(),
+	}
+	if st.Probes > 0 {
+		st.HitRate = float64(st.Hits) / float64(st.Probes)
+	}
+	return st
+}
+
+// ---------------------------------------------------------
+// Table sharing between games
+// ---------------------------------------------------------
+
+type TableMode string
+
+const (
+	// SharedTables: every bot game searches through one process-wide table.
+	SharedTables TableMode = "shared"
+	// IsolatedTables: each game gets its own table, dropped when the game ends.
+	IsolatedTables TableMode = "isolated"
+)
+
+type TableConfig struct {
+	Mode    TableMode
+	Entries int
+}
+
+// TableSettings is read when a table is first needed; set it at startup.
+var TableSettings = TableConfig{Mode: SharedTables, Entries: 1 << 20}
+
+var tables = struct {
+	mu      sync.Mutex
+	shared  *Table
+	perGame map[string]*Table
+}{perGame: make(map[string]*Table)}
+
+// TableForGame returns the table a game's bot should search with.
+func TableForGame(gameID string) *Table {
+	tables.mu.Lock()
+	defer tables.mu.Unlock()
+
+	if TableSettings.Mode == IsolatedTables {
+		t, ok := tables.perGame[gameID]
+		if !ok {
+			// Per-game tables only ever see one game, keep them small
+			t = NewTable(TableSettings.Entries / 16)
+			tables.perGame[gameID] = t
+		}
+		return t
+	}
+
+	if tables.shared == nil {
+		tables.shared = NewTable(TableSettings.Entries)
+	}
+	return tables.shared
+}
+
+// ReleaseGame frees a game's isolated table. It is a no-op in shared mode.
+func ReleaseGame(gameID string) {
+	tables.mu.Lock()
+	defer tables.mu.Unlock()
+	delete(tables.perGame, gameID)
+}
+
+// Stats sums the counters of every live table.
+func Stats() TableStats {
+	tables.mu.Lock()
+	all := make([]*Table, 0, len(tables.perGame)+1)
+	if tables.shared != nil {
+		all = append(all, tables.shared)
+	}
+	for _, t := range tables.perGame {
+		all = append(all, t)
+	}
+	tables.mu.Unlock()
+
+	var sum TableStats
+	for _, t := range all {
+		s := t.Stats()
+		sum.Entries += s.Entries
+		sum.Probes += s.Probes
+		sum.Hits += s.Hits
+		sum.Stores += s.Stores
+		sum.Replaced += s.Replaced
+		sum.Rejected += s.Rejected
+	}
+	if sum.Probes > 0 {
+		sum.HitRate = float64(sum.Hits) / float64(sum.Probes)
+	}
+	return sum
+}
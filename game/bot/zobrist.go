@@ -0,0 +1,40 @@
+package bot
+
+import "math/rand"
+
+// Zobrist keys: one random 64-bit value per (row, column, color) plus one for
+// "player 2 to move". XOR-ing the keys of every occupied cell gives a position
+// hash that can be updated incrementally when a disc is dropped.
+var (
+	zobristCells [6][7][3]uint64
+	zobristSide  uint64
+)
+
+func init() {
+	// Fixed seed so hashes are stable between runs (useful when debugging
+	// table dumps or comparing engine logs).
+	rng := rand.New(rand.NewSource(0x4f4e4e454354))
+	for r := 0; r < 6; r++ {
+		for c := 0; c < 7; c++ {
+			zobristCells[r][c][1] = rng.Uint64()
+			zobristCells[r][c][2] = rng.Uint64()
+		}
+	}
+	zobristSide = rng.Uint64()
+}
+
+// Hash returns the Zobrist hash of a board with `toMove` (1 or 2) to play.
+func Hash(board [6][7]int, toMove int) uint64 {
+	var h uint64
+	for r := 0; r < 6; r++ {
+		for c := 0; c < 7; c++ {
+			if p := board[r][c]; p == 1 || p == 2 {
+				h ^= zobristCells[r][c][p]
+			}
+		}
+	}
+	if toMove == 2 {
+		h ^= zobristSide
+	}
+	return h
+}
@@ -2,6 +2,8 @@ package game
 
 import (
 	"errors"
+	"fmt"
+	"time"
 )
 
 func ApplyMove(g *Game, playerID string, col int) error {
@@ -51,11 +53,13 @@ func ApplyMove(g *Game, playerID string, col int) error {
 
 	// 3. Update Board
 	g.Board[rowIndex][col] = playerColor
+	g.Moves = append(g.Moves, Move{PlayerID: playerID, Color: playerColor, Column: col, Row: rowIndex, At: time.Now()})
 
 	// 4. Check Win
 	if CheckWin(g.Board, playerColor) {
 		g.Status = "finished"
 		g.Winner = playerID
+		g.FinishReason = FinishFourInRow
 		return nil
 	}
 
@@ -70,6 +74,7 @@ func ApplyMove(g *Game, playerID string, col int) error {
 	if isFull {
 		g.Status = "finished"
 		g.Winner = "draw"
+		g.FinishReason = FinishBoardFull
 		return nil
 	}
 
@@ -122,4 +127,54 @@ func CheckWin(b [6][7]int, color int) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
+// Replay plays a list of columns from the empty board through ApplyMove, with
+// player 1 ("p1") moving first. It is used to rebuild positions for analysis.
+func Replay(moves []int) (*Game, error) {
+	g := &Game{
+		ID:          "replay",
+		Players:     make(map[string]*Player),
+		Status:      "playing",
+		CurrentTurn: "p1",
+	}
+	g.Players["p1"] = &Player{ID: "p1", Username: "p1", Color: 1}
+	g.Players["p2"] = &Player{ID: "p2", Username: "p2", Color: 2}
+
+	for i, col := range moves {
+		if err := ApplyMove(g, g.CurrentTurn, col); err != nil {
+			return nil, fmt.Errorf("move %d: %w", i+1, err)
+		}
+	}
+	return g, nil
+}
+
+// ValidateBoard checks that a board could have come from a real game (no
+// floating discs, sensible disc counts) and returns the color to move.
+func ValidateBoard(b [6][7]int) (int, error) {
+	count := [3]int{}
+	for c := 0; c < 7; c++ {
+		empty := false
+		for r := 5; r >= 0; r-- {
+			v := b[r][c]
+			if v < 0 || v > 2 {
+				return 0, errors.New("invalid cell value")
+			}
+			if v == 0 {
+				empty = true
+				continue
+			}
+			if empty {
+				return 0, errors.New("floating disc")
+			}
+			count[v]++
+		}
+	}
+
+	switch count[1] - count[2] {
+	case 0:
+		return 1, nil
+	case 1:
+		return 2, nil
+	}
+	return 0, errors.New("invalid disc count")
+}
@@ -1,32 +1,60 @@
 package game
 
-import (
-	"github.com/gorilla/websocket"
-	"time"
-)
+import "time"
+
+// Conn is where a player's messages go: their websocket, or a relay to the
+// node they are connected to.
+type Conn interface {
+	WriteJSON(v interface{}) error
+}
 
 type Player struct {
-	ID              string          `json:"id"`
-	Username        string          `json:"username"`
-	Color           int             `json:"color"` 
-	Conn            *websocket.Conn `json:"-"`     
-	IsBot           bool            `json:"isBot"`
-	IsConnected     bool            `json:"isConnected"`
-	DisconnectTimer *time.Timer     `json:"-"` // Needed for 30s timeout
-	GameID          string          `json:"gameId"`
+	ID              string      `json:"id"`
+	Username        string      `json:"username"`
+	Color           int         `json:"color"`
+	Conn            Conn        `json:"-"`
+	IsBot           bool        `json:"isBot"`
+	BotProfile      string      `json:"botProfile,omitempty"` // bot.Profiles key
+	Avatar          string      `json:"avatar,omitempty"`
+	IsConnected     bool        `json:"isConnected"`
+	DisconnectTimer *time.Timer `json:"-"` // Needed for 30s timeout
+	DisconnectedAt  time.Time   `json:"-"`
+	GameID          string      `json:"gameId"`
 }
 
 type Game struct {
-	ID          string             `json:"id"`
-	Board       [6][7]int          `json:"board"`
-	Players     map[string]*Player `json:"players"`
-	CurrentTurn string             `json:"currentTurn"` 
-	Status      string             `json:"status"`      
-	Winner      string             `json:"winner,omitempty"`
-	CreatedAt   time.Time          `json:"-"`
+	ID           string             `json:"id"`
+	Board        [6][7]int          `json:"board"`
+	Players      map[string]*Player `json:"players"`
+	CurrentTurn  string             `json:"currentTurn"`
+	Status       string             `json:"status"`
+	Winner       string             `json:"winner,omitempty"`
+	FinishReason string             `json:"finishReason,omitempty"`
+	Moves        []Move             `json:"moves"`
+	Rated        bool               `json:"rated"`
+	HintsUsed    map[string]int     `json:"-"` // keyed by username
+	CreatedAt    time.Time          `json:"-"`
+	FinishedAt   time.Time          `json:"-"` // set for imported games; live games finish "now"
+}
+
+// Why a game finished
+const (
+	FinishFourInRow   = "four_in_row"
+	FinishBoardFull   = "board_full"
+	FinishDisconnect  = "disconnect"
+	FinishAdjudicated = "adjudicated" // nobody came back after a restart
+)
+
+// Move is one disc drop, in the order it was played.
+type Move struct {
+	PlayerID string    `json:"playerId"`
+	Color    int       `json:"color"`
+	Column   int       `json:"column"`
+	Row      int       `json:"row"`
+	At       time.Time `json:"at"`
 }
 
 type WSMessage struct {
-	Type    string      `json:"type"` 
+	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
-}
\ No newline at end of file
+}
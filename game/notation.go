@@ -0,0 +1,350 @@
+package game
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archive notation is a PGN-style text form of a game:
+//
+//	[Game "3f2a..."]
+//	[Date "2026.10.19"]
+//	[Time "08:48:06"]
+//	[Red "alice"]
+//	[Yellow "bob"]
+//	[RedType "human"]
+//	[YellowType "bot"]
+//	[Rules "connect4 7x6"]
+//	[Result "1-0"]
+//	[Termination "four_in_row"]
+//
+//	4455667 1-0
+//
+// Moves are columns numbered 1-7 from the left; Red (color 1) moves first.
+// The result token is "1-0" (Red won), "0-1" (Yellow won), "1/2-1/2" or "*"
+// for an unfinished game. Date and Time are when the game finished, in UTC.
+const (
+	ArchiveRules = "connect4 7x6"
+
+	ResultRed        = "1-0"
+	ResultYellow     = "0-1"
+	ResultDraw       = "1/2-1/2"
+	ResultUnfinished = "*"
+
+	archiveDate = "2006.01.02"
+	archiveTime = "15:04:05"
+)
+
+type Header struct {
+	Key   string
+	Value string
+}
+
+// Archive is one game in archive notation. Moves are 0-based columns.
+type Archive struct {
+	Headers []Header
+	Moves   []int
+	Result  string
+}
+
+// Get returns a header value, or "" if it is missing.
+func (a *Archive) Get(key string) string {
+	for _, h := range a.Headers {
+		if h.Key == key {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+func (a *Archive) Set(key, value string) {
+	for i, h := range a.Headers {
+		if h.Key == key {
+			a.Headers[i].Value = value
+			return
+		}
+	}
+	a.Headers = append(a.Headers, Header{Key: key, Value: value})
+}
+
+// SetFinished sets the Date and Time headers.
+func (a *Archive) SetFinished(t time.Time) {
+	t = t.UTC()
+	a.Set("Date", t.Format(archiveDate))
+	a.Set("Time", t.Format(archiveTime))
+}
+
+// Finished parses the Date and Time headers. Time is optional.
+func (a *Archive) Finished() (time.Time, error) {
+	if a.Get("Time") == "" {
+		return time.Parse(archiveDate, a.Get("Date"))
+	}
+	return time.Parse(archiveDate+" "+archiveTime, a.Get("Date")+" "+a.Get("Time"))
+}
+
+// WriteTo writes the archive followed by a blank line, so archives can be
+// concatenated into one file.
+func (a *Archive) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	for _, h := range a.Headers {
+		fmt.Fprintf(&sb, "[%s %s]\n", h.Key, strconv.Quote(h.Value))
+	}
+	sb.WriteByte('\n')
+	for _, col := range a.Moves {
+		sb.WriteByte(byte('1' + col))
+	}
+	if len(a.Moves) > 0 {
+		sb.WriteByte(' ')
+	}
+	sb.WriteString(a.Result)
+	sb.WriteString("\n\n")
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+func (a *Archive) String() string {
+	var sb strings.Builder
+	a.WriteTo(&sb)
+	return sb.String()
+}
+
+// ArchiveReader reads a stream of archives one at a time.
+type ArchiveReader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func NewArchiveReader(r io.Reader) *ArchiveReader {
+	return &ArchiveReader{sc: bufio.NewScanner(r)}
+}
+
+// Next returns the next archive, or io.EOF when the input is exhausted.
+func (ar *ArchiveReader) Next() (*Archive, error) {
+	a := &Archive{}
+	started := false
+	for ar.sc.Scan() {
+		ar.line++
+		line := strings.TrimSpace(ar.sc.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "["):
+			h, err := parseHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", ar.line, err)
+			}
+			a.Headers = append(a.Headers, h)
+			started = true
+		default:
+			// Move text runs until the result token
+			done, err := a.parseMoves(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", ar.line, err)
+			}
+			started = true
+			if done {
+				return a, nil
+			}
+		}
+	}
+	if err := ar.sc.Err(); err != nil {
+		return nil, err
+	}
+	if started {
+		return nil, fmt.Errorf("line %d: missing result", ar.line)
+	}
+	return nil, io.EOF
+}
+
+func parseHeader(line string) (Header, error) {
+	if !strings.HasSuffix(line, "]") {
+		return Header{}, errors.New("unterminated header")
+	}
+	key, value, ok := strings.Cut(line[1:len(line)-1], " ")
+	if !ok || key == "" {
+		return Header{}, errors.New("malformed header")
+	}
+	value, err := strconv.Unquote(strings.TrimSpace(value))
+	if err != nil {
+		return Header{}, fmt.Errorf("header %s: value must be quoted", key)
+	}
+	return Header{Key: key, Value: value}, nil
+}
+
+// parseMoves reads one line of move text and reports whether it ended with the
+// result token.
+func (a *Archive) parseMoves(line string) (bool, error) {
+	for _, tok := range strings.Fields(line) {
+		if a.Result != "" {
+			return false, fmt.Errorf("unexpected %q after result", tok)
+		}
+		switch tok {
+		case ResultRed, ResultYellow, ResultDraw, ResultUnfinished:
+			a.Result = tok
+			continue
+		}
+		for _, c := range tok {
+			if c < '1' || c > '7' {
+				return false, fmt.Errorf("invalid column %q", c)
+			}
+			a.Moves = append(a.Moves, int(c-'1'))
+		}
+	}
+	return a.Result != "", nil
+}
+
+// ResultFor returns the result token for a finished game.
+func ResultFor(g *Game) string {
+	if g.Status != "finished" {
+		return ResultUnfinished
+	}
+	if g.Winner == "draw" {
+		return ResultDraw
+	}
+	for _, p := range g.Players {
+		if p.ID == g.Winner {
+			if p.Color == 1 {
+				return ResultRed
+			}
+			return ResultYellow
+		}
+	}
+	return ResultUnfinished
+}
+
+// Game replays the archive through ApplyMove and checks that the final
+// position agrees with the Result header. Games that ended by disconnect
+// stop early, so their result is taken from the header.
+func (a *Archive) Game() (*Game, error) {
+	if rules := a.Get("Rules"); rules != "" && rules != ArchiveRules {
+		return nil, fmt.Errorf("unsupported rules %q", rules)
+	}
+
+	g, err := Replay(a.Moves)
+	if err != nil {
+		return nil, err
+	}
+	g.ID = a.Get("Game")
+	g.Players["p1"].Username = a.Get("Red")
+	g.Players["p2"].Username = a.Get("Yellow")
+	g.Players["p1"].IsBot = a.Get("RedType") == "bot"
+	g.Players["p2"].IsBot = a.Get("YellowType") == "bot"
+	if g.Players["p1"].Username == "" || g.Players["p2"].Username == "" {
+		return nil, errors.New("missing Red or Yellow header")
+	}
+	if t, err := a.Finished(); err == nil {
+		g.CreatedAt, g.FinishedAt = t, t
+		for i := range g.Moves {
+			g.Moves[i].At = t
+		}
+	}
+
+	if g.Status == "finished" {
+		if got := ResultFor(g); got != a.Result {
+			return nil, fmt.Errorf("result %s does not match the moves (%s)", a.Result, got)
+		}
+		return g, nil
+	}
+
+	switch a.Result {
+	case ResultRed:
+		g.Winner = "p1"
+	case ResultYellow:
+		g.Winner = "p2"
+	case ResultDraw:
+		return nil, errors.New("draw declared before the board is full")
+	default:
+		return g, nil
+	}
+	g.Status = "finished"
+	g.FinishReason = a.Get("Termination")
+	if g.FinishReason == "" {
+		g.FinishReason = FinishDisconnect
+	}
+	return g, nil
+}
+
+// PositionString encodes a board FEN-style: rows from top to bottom separated
+// by "/", "r" and "y" for discs, digits for runs of empty cells, then the side
+// to move. The empty board is "7/7/7/7/7/7 r".
+func PositionString(b [6][7]int) string {
+	var sb strings.Builder
+	count := [3]int{}
+	for r := 0; r < 6; r++ {
+		if r > 0 {
+			sb.WriteByte('/')
+		}
+		empty := 0
+		for c := 0; c < 7; c++ {
+			v := b[r][c]
+			if v == 0 {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				sb.WriteByte(byte('0' + empty))
+				empty = 0
+			}
+			sb.WriteByte(" ry"[v])
+			count[v]++
+		}
+		if empty > 0 {
+			sb.WriteByte(byte('0' + empty))
+		}
+	}
+	if count[1] > count[2] {
+		sb.WriteString(" y")
+	} else {
+		sb.WriteString(" r")
+	}
+	return sb.String()
+}
+
+// ParsePosition decodes a PositionString and returns the board and the color
+// to move. The board is checked with ValidateBoard.
+func ParsePosition(s string) ([6][7]int, int, error) {
+	var b [6][7]int
+	rowsPart, side, _ := strings.Cut(strings.TrimSpace(s), " ")
+	rows := strings.Split(rowsPart, "/")
+	if len(rows) != 6 {
+		return b, 0, errors.New("position must have 6 rows")
+	}
+
+	for r, row := range rows {
+		c := 0
+		for _, ch := range row {
+			switch {
+			case ch >= '1' && ch <= '7':
+				c += int(ch - '0')
+			case ch == 'r' || ch == 'y':
+				if c < 7 {
+					b[r][c] = 1
+					if ch == 'y' {
+						b[r][c] = 2
+					}
+				}
+				c++
+			default:
+				return b, 0, fmt.Errorf("invalid character %q in position", ch)
+			}
+		}
+		if c != 7 {
+			return b, 0, fmt.Errorf("row %d has %d cells, want 7", r+1, c)
+		}
+	}
+
+	toMove, err := ValidateBoard(b)
+	if err != nil {
+		return b, 0, err
+	}
+	if side != "" && side != " ry"[toMove:toMove+1] {
+		return b, 0, errors.New("side to move does not match the disc count")
+	}
+	return b, toMove, nil
+}
@@ -0,0 +1,16 @@
+package game
+
+import "math"
+
+const (
+	DefaultRating = 1200
+	RatingK       = 32
+)
+
+// Elo returns both players' new ratings after a game. scoreA is 1 if A won,
+// 0.5 for a draw and 0 if A lost.
+func Elo(ratingA, ratingB int, scoreA float64) (int, int) {
+	expectedA := 1 / (1 + math.Pow(10, float64(ratingB-ratingA)/400))
+	delta := int(math.Round(RatingK * (scoreA - expectedA)))
+	return ratingA + delta, ratingB - delta
+}
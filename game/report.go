@@ -0,0 +1,41 @@
+package game
+
+import "time"
+
+// Move classifications used in post-game reports, from best to worst.
+const (
+	QualityBest       = "best"
+	QualityGood       = "good"
+	QualityInaccuracy = "inaccuracy"
+	QualityMistake    = "mistake"
+	QualityBlunder    = "blunder"
+)
+
+// MoveReview is the engine's verdict on a single move.
+type MoveReview struct {
+	Ply       int     `json:"ply"`
+	PlayerID  string  `json:"playerId"`
+	Color     int     `json:"color"`
+	Column    int     `json:"column"`
+	BestMove  int     `json:"bestMove"`
+	Score     int     `json:"score"`     // engine score of the move played
+	BestScore int     `json:"bestScore"` // engine score of the best move
+	Loss      float64 `json:"loss"`      // expected result given away, 0..1
+	Quality   string  `json:"quality"`
+}
+
+// PlayerAccuracy summarises one player's moves in a report.
+type PlayerAccuracy struct {
+	Username string         `json:"username"`
+	Color    int            `json:"color"`
+	Accuracy float64        `json:"accuracy"` // percentage, 0..100
+	Counts   map[string]int `json:"counts"`   // moves per quality
+}
+
+// Report is the post-game blunder report.
+type Report struct {
+	GameID    string           `json:"gameId"`
+	Players   []PlayerAccuracy `json:"players"`
+	Moves     []MoveReview     `json:"moves"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
@@ -0,0 +1,67 @@
+package game
+
+import "time"
+
+// Snapshot is the persisted state of a game in progress, enough to rebuild it
+// after a restart. Connections and timers are not part of it.
+type Snapshot struct {
+	ID          string             `json:"id"`
+	Board       [6][7]int          `json:"board"`
+	Players     map[string]*Player `json:"players"`
+	CurrentTurn string             `json:"currentTurn"`
+	Moves       []Move             `json:"moves"`
+	Rated       bool               `json:"rated"`
+	HintsUsed   map[string]int     `json:"hintsUsed,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	UpdatedAt   time.Time          `json:"updatedAt"` // last move, or the start
+}
+
+// TakeSnapshot copies the parts of a live game that survive a restart.
+func TakeSnapshot(g *Game) *Snapshot {
+	s := &Snapshot{
+		ID:          g.ID,
+		Board:       g.Board,
+		Players:     make(map[string]*Player, len(g.Players)),
+		CurrentTurn: g.CurrentTurn,
+		Moves:       append([]Move(nil), g.Moves...),
+		Rated:       g.Rated,
+		CreatedAt:   g.CreatedAt,
+		UpdatedAt:   g.CreatedAt,
+	}
+	for key, p := range g.Players {
+		s.Players[key] = &Player{
+			ID: p.ID, Username: p.Username, Color: p.Color, IsBot: p.IsBot,
+			BotProfile: p.BotProfile, Avatar: p.Avatar, IsConnected: p.IsConnected, GameID: p.GameID,
+		}
+	}
+	if len(g.HintsUsed) > 0 {
+		s.HintsUsed = make(map[string]int, len(g.HintsUsed))
+		for k, v := range g.HintsUsed {
+			s.HintsUsed[k] = v
+		}
+	}
+	if n := len(g.Moves); n > 0 {
+		s.UpdatedAt = g.Moves[n-1].At
+	}
+	return s
+}
+
+// Restore rebuilds the game. Human players come back disconnected until they
+// rejoin.
+func (s *Snapshot) Restore() *Game {
+	g := &Game{
+		ID:          s.ID,
+		Board:       s.Board,
+		Players:     s.Players,
+		CurrentTurn: s.CurrentTurn,
+		Status:      "playing",
+		Moves:       s.Moves,
+		Rated:       s.Rated,
+		HintsUsed:   s.HintsUsed,
+		CreatedAt:   s.CreatedAt,
+	}
+	for _, p := range g.Players {
+		p.IsConnected = p.IsBot
+	}
+	return g
+}
@@ -1,20 +1,38 @@
 package game
 
-import "sync"
+import (
+	"log"
+	"sync"
+	"time"
+)
 
 type GameStore struct {
-	mu    sync.RWMutex
-	games map[string]*Game
+	mu       sync.RWMutex
+	games    map[string]*Game
+	byPlayer map[string]string    // username -> ID of their game in progress
+	finished map[string]time.Time // game ID -> when it finished
+	evicted  int
 }
 
-var Store = &GameStore{
-	games: make(map[string]*Game),
+var Store = NewGameStore()
+
+func NewGameStore() *GameStore {
+	return &GameStore{
+		games:    make(map[string]*Game),
+		byPlayer: make(map[string]string),
+		finished: make(map[string]time.Time),
+	}
 }
 
 func (s *GameStore) AddGame(g *Game) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.games[g.ID] = g
+	for name, p := range g.Players {
+		if !p.IsBot {
+			s.byPlayer[name] = g.ID
+		}
+	}
 }
 
 func (s *GameStore) GetGame(id string) *Game {
@@ -23,18 +41,91 @@ func (s *GameStore) GetGame(id string) *Game {
 	return s.games[id]
 }
 
+// FinishGame takes a finished game out of the player index. The game itself
+// stays readable until Evict drops it.
+func (s *GameStore) FinishGame(g *Game) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.finished[g.ID]; ok {
+		return
+	}
+	s.finished[g.ID] = time.Now()
+	for name := range g.Players {
+		if s.byPlayer[name] == g.ID {
+			delete(s.byPlayer, name)
+		}
+	}
+}
+
 // FindGameByPlayerName finds an active game for reconnection
 func (s *GameStore) FindGameByPlayerName(username string) *Game {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
-    
-    for _, g := range s.games {
-        // Only look for games that are still playing
-        if g.Status == "playing" {
-            if _, ok := g.Players[username]; ok {
-                return g
-            }
-        }
-    }
-    return nil
-}
\ No newline at end of file
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g := s.games[s.byPlayer[username]]
+	// Only look for games that are still playing
+	if g == nil || g.Status != "playing" {
+		return nil
+	}
+	return g
+}
+
+// Evict drops games that finished more than `retention` ago, stopping any
+// timers and letting go of their connections. It returns how many it dropped.
+func (s *GameStore) Evict(retention time.Duration) int {
+	cutoff := time.Now().Add(-retention)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, at := range s.finished {
+		if at.After(cutoff) {
+			continue
+		}
+		if g := s.games[id]; g != nil {
+			for _, p := range g.Players {
+				if p.DisconnectTimer != nil {
+					p.DisconnectTimer.Stop()
+				}
+				p.Conn = nil
+			}
+		}
+		delete(s.games, id)
+		delete(s.finished, id)
+		n++
+	}
+	s.evicted += n
+	return n
+}
+
+type StoreStats struct {
+	Games    int `json:"games"` // everything held, live and finished
+	Live     int `json:"live"`
+	Finished int `json:"finished"`
+	Players  int `json:"players"` // usernames with a game in progress
+	Evicted  int `json:"evicted"` // since startup
+}
+
+func (s *GameStore) Stats() StoreStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return StoreStats{
+		Games:    len(s.games),
+		Live:     len(s.games) - len(s.finished),
+		Finished: len(s.finished),
+		Players:  len(s.byPlayer),
+		Evicted:  s.evicted,
+	}
+}
+
+// StartJanitor evicts finished games every interval and logs the store size.
+func (s *GameStore) StartJanitor(interval, retention time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			n := s.Evict(retention)
+			st := s.Stats()
+			log.Printf("[STORE] evicted=%d games=%d live=%d finished=%d players=%d evicted_total=%d",
+				n, st.Games, st.Live, st.Finished, st.Players, st.Evicted)
+		}
+	}()
+}
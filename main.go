@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"fourinrow/analytics"
+	"fourinrow/cluster"
 	"fourinrow/db"
+	"fourinrow/game"
+	"fourinrow/game/bot"
+	"fourinrow/metrics"
 	"fourinrow/server"
+
+	"github.com/IBM/sarama"
 )
 
 // spaHandler serves the index.html for any unknown route to support React Router (SPA)
@@ -42,46 +54,219 @@ func main() {
 	db.InitDB()
 
 	// 2. Initialize Analytics
-	// Check for environment variable first (for Cloud), otherwise default to localhost
-	kafkaUrl := os.Getenv("KAFKA_BROKER")
-	var kafkaBrokers []string
-	if kafkaUrl != "" {
-		kafkaBrokers = []string{kafkaUrl}
+	// ANALYTICS_SINKS picks where events go: any of kafka, file and log,
+	// comma separated (default kafka). Several sinks each get every event.
+	analytics.Producer = newProducer(os.Getenv("ANALYTICS_SINKS"))
+
+    defer analytics.Producer.Close()
+
+	// Events are written to the database outbox with the game they describe;
+	// the relay publishes them, checking every OUTBOX_INTERVAL (default 1s)
+	if d, err := time.ParseDuration(os.Getenv("OUTBOX_INTERVAL")); err == nil && d > 0 {
+		server.OutboxPollInterval = d
+	}
+	server.StartOutboxRelay()
+
+	// 3. Join the cluster. Without CLUSTER_URL this node is a cluster of one;
+	// with a redis:// URL, nodes share the matchmaking queue and game index.
+	server.NodeID = os.Getenv("NODE_ID")
+	if server.NodeID == "" {
+		server.NodeID, _ = os.Hostname()
+	}
+	if backend, err := cluster.Open(os.Getenv("CLUSTER_URL")); err != nil {
+		log.Printf("[CLUSTER] ⚠️ Backend unavailable: %v (running as a single node)", err)
 	} else {
-		kafkaBrokers = []string{"localhost:9092"}
+		server.Cluster = backend
+	}
+	defer server.Cluster.Close()
+	if err := server.StartCluster(); err != nil {
+		log.Fatalf("[CLUSTER] Failed to subscribe: %v", err)
 	}
 
-	// --- FIX START: TYPED NIL CHECK ---
-	// We capture the pointer returned by NewKafkaProducer directly.
-	// We MUST check if this specific pointer is nil BEFORE assigning it to the interface.
-	kafkaProducer := analytics.NewKafkaProducer(kafkaBrokers, "game-events")
+	// 4. Configure the Bot Engine
+	// BOT_TT_MODE=isolated gives every bot game its own transposition table
+	switch mode := bot.TableMode(os.Getenv("BOT_TT_MODE")); mode {
+	case "":
+	case bot.SharedTables, bot.IsolatedTables:
+		bot.TableSettings.Mode = mode
+	default:
+		log.Fatalf("[BOT] Unknown transposition table mode %q (want %s or %s)", mode, bot.SharedTables, bot.IsolatedTables)
+	}
+	if n, err := strconv.Atoi(os.Getenv("BOT_TT_ENTRIES")); err == nil && n > 0 {
+		bot.TableSettings.Entries = n
+	}
+	if d, err := strconv.Atoi(os.Getenv("BOT_SEARCH_DEPTH")); err == nil && d >= 0 {
+		bot.SearchDepth = d
+	}
 
-	if kafkaProducer != nil {
-		// It worked! Assign the valid pointer to the global interface
-		analytics.Producer = kafkaProducer
-	} else {
-		// It failed! Use the Stub instead
-		analytics.Producer = analytics.NewStubProducer()
+	// 5. Leaderboard snapshot, rebuilt every LEADERBOARD_REFRESH (default 1m)
+	refresh := time.Minute
+	if d, err := time.ParseDuration(os.Getenv("LEADERBOARD_REFRESH")); err == nil && d > 0 {
+		refresh = d
 	}
-	// --- FIX END ---
-	
-    defer analytics.Producer.Close()
+	if n, err := strconv.Atoi(os.Getenv("LEADERBOARD_MIN_GAMES")); err == nil && n >= 0 {
+		server.MinGamesForWinRate = n
+	}
+	server.StartLeaderboardRefresher(refresh)
+
+	// 6. Restore games that were in progress when the server last stopped.
+	// SNAPSHOT_INTERVAL=0 (default) snapshots live games after every move.
+	if d, err := time.ParseDuration(os.Getenv("SNAPSHOT_INTERVAL")); err == nil && d >= 0 {
+		server.SnapshotInterval = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("RECONNECT_GRACE")); err == nil && d > 0 {
+		server.ReconnectGrace = d
+	}
+	server.RestoreLiveGames()
+	server.StartSnapshotter()
 
-	// 3. Setup Routes
+	// Finished games stay in memory for GAME_RETENTION (default 5m) so late
+	// requests can still read them
+	retention := 5 * time.Minute
+	if d, err := time.ParseDuration(os.Getenv("GAME_RETENTION")); err == nil && d > 0 {
+		retention = d
+	}
+	game.Store.StartJanitor(time.Minute, retention)
+
+	// 7. Setup Routes
+	server.AdminToken = os.Getenv("ADMIN_TOKEN")
 	http.HandleFunc("/ws", server.WebSocketHandler)
 	http.HandleFunc("/leaderboard", server.LeaderboardHandler)
+	http.HandleFunc("POST /analysis", server.AnalysisHandler)
+	http.HandleFunc("GET /games/{id}/report", server.ReportHandler)
+	http.HandleFunc("GET /games/{file}", server.GameArchiveHandler)
+	http.HandleFunc("GET /games/export", server.ExportHandler)
+	http.HandleFunc("POST /games/import", server.ImportHandler)
+	http.HandleFunc("GET /players/{name}", server.PlayerHandler)
+	http.HandleFunc("GET /players/{a}/vs/{b}", server.HeadToHeadHandler)
+	http.Handle("GET /metrics", metrics.Handler())
 
-	// 4. Serve Frontend
+	// 8. Serve Frontend
 	spa := spaHandler{staticPath: "./client/dist", indexPath: "index.html"}
 	http.Handle("/", spa)
 
-	// 5. Start Server (Cloud Compatible)
+	// 9. Start Server (Cloud Compatible)
 	// Render/Heroku provide the PORT variable. We must use it.
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "5000" // Default for local development
 	}
 	
-	log.Printf("Server running on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	// Stop on SIGINT/SIGTERM so the deferred closes run and the analytics
+	// producer flushes what it has buffered
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: ":" + port}
+	go func() {
+		log.Printf("Server running on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+}
+
+// newProducer builds the configured analytics sinks. A sink that fails to
+// start is left out; with none left, events are logged.
+func newProducer(sinks string) analytics.ProducerInterface {
+	if sinks == "" {
+		sinks = "kafka"
+	}
+
+	var producers []analytics.ProducerInterface
+	for _, sink := range strings.Split(sinks, ",") {
+		switch sink = strings.TrimSpace(sink); sink {
+		case "kafka":
+			// Check for environment variable first (for Cloud), otherwise default to localhost
+			kafkaBrokers := []string{"localhost:9092"}
+			if kafkaUrl := os.Getenv("KAFKA_BROKER"); kafkaUrl != "" {
+				kafkaBrokers = []string{kafkaUrl}
+			}
+
+			// --- FIX START: TYPED NIL CHECK ---
+			// We MUST check the returned pointer for nil BEFORE adding it as an interface.
+			// KAFKA_PRODUCER=sync sends each event inline and waits for the broker;
+			// the default async producer buffers and batches them in the background.
+			if os.Getenv("KAFKA_PRODUCER") == "sync" {
+				if kafkaProducer := analytics.NewKafkaProducer(kafkaBrokers, "game-events"); kafkaProducer != nil {
+					producers = append(producers, kafkaProducer)
+				}
+			} else if asyncProducer := analytics.NewAsyncProducer(kafkaBrokers, "game-events", asyncConfig()); asyncProducer != nil {
+				producers = append(producers, asyncProducer)
+			}
+			// --- FIX END ---
+
+		case "file":
+			cfg := analytics.FileConfig{Dir: os.Getenv("ANALYTICS_FILE_DIR")}
+			if n, err := strconv.Atoi(os.Getenv("ANALYTICS_FILE_MAX_MB")); err == nil && n > 0 {
+				cfg.MaxBytes = int64(n) << 20
+			}
+			if n, err := strconv.Atoi(os.Getenv("ANALYTICS_FILE_KEEP")); err == nil && n >= 0 {
+				cfg.MaxFiles = n
+			}
+			if fileProducer, err := analytics.NewFileProducer(cfg); err != nil {
+				log.Printf("[ANALYTICS] ⚠️ Failed to start file producer: %v", err)
+			} else {
+				producers = append(producers, fileProducer)
+			}
+
+		case "log":
+			producers = append(producers, analytics.NewStubProducer())
+
+		default:
+			log.Printf("[ANALYTICS] ⚠️ Unknown sink %q", sink)
+		}
+	}
+
+	switch len(producers) {
+	case 0:
+		log.Println("[ANALYTICS] ⚠️ No analytics sink started (Using Stub instead)")
+		return analytics.NewStubProducer()
+	case 1:
+		return producers[0]
+	default:
+		return analytics.NewFanOut(producers...)
+	}
+}
+
+// asyncConfig reads the async analytics producer settings from ANALYTICS_*
+// variables; anything unset keeps the producer's default. The outbox relay
+// sends its batches with SendAll and keeps failures in the outbox, so the
+// buffer and overflow settings only apply to events whose outbox write failed.
+func asyncConfig() analytics.AsyncConfig {
+	cfg := analytics.AsyncConfig{
+		Compression: sarama.CompressionSnappy,
+		Overflow:    analytics.OverflowPolicy(os.Getenv("ANALYTICS_OVERFLOW")),
+		SpillPath:   os.Getenv("ANALYTICS_SPILL_FILE"),
+	}
+	switch cfg.Overflow {
+	case "", analytics.OverflowDropOldest, analytics.OverflowBlock, analytics.OverflowSpill:
+	default:
+		log.Printf("[ANALYTICS] Unknown overflow policy %q, using %s", cfg.Overflow, analytics.OverflowDropOldest)
+		cfg.Overflow = analytics.OverflowDropOldest
+	}
+	if n, err := strconv.Atoi(os.Getenv("ANALYTICS_BUFFER")); err == nil {
+		cfg.Buffer = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("ANALYTICS_BATCH_SIZE")); err == nil {
+		cfg.BatchSize = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("ANALYTICS_LINGER")); err == nil {
+		cfg.Linger = d
+	}
+	if c := os.Getenv("ANALYTICS_COMPRESSION"); c != "" {
+		if err := cfg.Compression.UnmarshalText([]byte(c)); err != nil {
+			log.Printf("[ANALYTICS] Unknown compression %q, using snappy", c)
+			cfg.Compression = sarama.CompressionSnappy
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("ANALYTICS_CLOSE_TIMEOUT")); err == nil {
+		cfg.CloseTimeout = d
+	}
+	return cfg
 }
\ No newline at end of file
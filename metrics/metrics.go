@@ -0,0 +1,311 @@
+// Package metrics is a small Prometheus-compatible instrumentation library:
+// counters, gauges and histograms, optionally split by labels, served in the
+// text exposition format.
+//
+//	var moves = metrics.NewCounterVec("fourinrow_moves_total", "Moves played.", "by")
+//	moves.With("bot").Inc()
+//	http.Handle("/metrics", metrics.Handler())
+//
+// Metrics register themselves in Default when created; names must be unique.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// family is a metric name with its help text, type and series.
+type family interface {
+	desc() (name, help, typ string)
+	// series calls fn for each sample, in a stable order
+	series(fn func(suffix string, labels []label, value float64))
+}
+
+type label struct {
+	name, value string
+}
+
+// ---------------------------------------------------------------------------
+// Counter and Gauge
+
+// Counter only goes up.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add increases the counter; negative values are ignored.
+func (c *Counter) Add(v float64) {
+	if v > 0 {
+		addFloat(&c.bits, v)
+	}
+}
+
+func (c *Counter) Value() float64 { return math.Float64frombits(c.bits.Load()) }
+
+// Gauge goes up and down.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64)  { g.bits.Store(math.Float64bits(v)) }
+func (g *Gauge) Add(v float64)  { addFloat(&g.bits, v) }
+func (g *Gauge) Inc()           { g.Add(1) }
+func (g *Gauge) Dec()           { g.Add(-1) }
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Histogram
+
+// DefBuckets suit latencies from a millisecond to ten seconds.
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count bounds starting at start, each factor
+// times the last.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	b := make([]float64, count)
+	for i := range b {
+		b[i] = start
+		start *= factor
+	}
+	return b
+}
+
+// Histogram counts observations into buckets by upper bound.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	b := append([]float64(nil), bounds...)
+	sort.Float64s(b)
+	return &Histogram{bounds: b, counts: make([]uint64, len(b)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// ObserveSince records the seconds elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) series(labels []label, fn func(string, []label, float64)) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	var cum uint64
+	for i, n := range counts {
+		cum += n
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+		fn("_bucket", append(labels[:len(labels):len(labels)], label{"le", le}), float64(cum))
+	}
+	fn("_sum", labels, sum)
+	fn("_count", labels, float64(count))
+}
+
+// ---------------------------------------------------------------------------
+// Families
+
+type meta struct {
+	name, help, typ string
+}
+
+func (m meta) desc() (string, string, string) { return m.name, m.help, m.typ }
+
+type counterFamily struct {
+	meta
+	c *Counter
+}
+
+func (f *counterFamily) series(fn func(string, []label, float64)) { fn("", nil, f.c.Value()) }
+
+type gaugeFamily struct {
+	meta
+	g *Gauge
+}
+
+func (f *gaugeFamily) series(fn func(string, []label, float64)) { fn("", nil, f.g.Value()) }
+
+type gaugeFuncFamily struct {
+	meta
+	fn func() float64
+}
+
+func (f *gaugeFuncFamily) series(fn func(string, []label, float64)) { fn("", nil, f.fn()) }
+
+type histogramFamily struct {
+	meta
+	h *Histogram
+}
+
+func (f *histogramFamily) series(fn func(string, []label, float64)) { f.h.series(nil, fn) }
+
+// vec holds one child metric per combination of label values.
+type vec[T any] struct {
+	meta
+	labels   []string
+	newChild func() *T
+	mu       sync.RWMutex
+	children map[string]*vecChild[T]
+}
+
+type vecChild[T any] struct {
+	labels []label
+	m      *T
+}
+
+func newVec[T any](m meta, labels []string, newChild func() *T) *vec[T] {
+	return &vec[T]{meta: m, labels: labels, newChild: newChild, children: make(map[string]*vecChild[T])}
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	c := v.children[key]
+	v.mu.RUnlock()
+	if c != nil {
+		return c.m
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c = v.children[key]; c == nil {
+		c = &vecChild[T]{m: v.newChild()}
+		for i, name := range v.labels {
+			c.labels = append(c.labels, label{name, values[i]})
+		}
+		v.children[key] = c
+	}
+	return c.m
+}
+
+// sorted returns the children ordered by label values.
+func (v *vec[T]) sorted() []*vecChild[T] {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*vecChild[T], len(keys))
+	for i, k := range keys {
+		out[i] = v.children[k]
+	}
+	return out
+}
+
+// CounterVec is a counter split by labels.
+type CounterVec struct{ *vec[Counter] }
+
+// With returns the counter for the label values, in the order the labels
+// were declared.
+func (v CounterVec) With(values ...string) *Counter { return v.with(values) }
+
+func (v CounterVec) series(fn func(string, []label, float64)) {
+	for _, c := range v.sorted() {
+		fn("", c.labels, c.m.Value())
+	}
+}
+
+// GaugeVec is a gauge split by labels.
+type GaugeVec struct{ *vec[Gauge] }
+
+func (v GaugeVec) With(values ...string) *Gauge { return v.with(values) }
+
+func (v GaugeVec) series(fn func(string, []label, float64)) {
+	for _, c := range v.sorted() {
+		fn("", c.labels, c.m.Value())
+	}
+}
+
+// HistogramVec is a histogram split by labels.
+type HistogramVec struct{ *vec[Histogram] }
+
+func (v HistogramVec) With(values ...string) *Histogram { return v.with(values) }
+
+func (v HistogramVec) series(fn func(string, []label, float64)) {
+	for _, c := range v.sorted() {
+		c.m.series(c.labels, fn)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Constructors, registering in Default
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	Default.register(&counterFamily{meta{name, help, "counter"}, c})
+	return c
+}
+
+func NewCounterVec(name, help string, labels ...string) CounterVec {
+	v := CounterVec{newVec(meta{name, help, "counter"}, labels, func() *Counter { return &Counter{} })}
+	Default.register(v)
+	return v
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	Default.register(&gaugeFamily{meta{name, help, "gauge"}, g})
+	return g
+}
+
+func NewGaugeVec(name, help string, labels ...string) GaugeVec {
+	v := GaugeVec{newVec(meta{name, help, "gauge"}, labels, func() *Gauge { return &Gauge{} })}
+	Default.register(v)
+	return v
+}
+
+// NewGaugeFunc reports fn's result at every scrape, for values that already
+// live elsewhere, like a queue's length. fn must be safe to call from any
+// goroutine.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.register(&gaugeFuncFamily{meta{name, help, "gauge"}, fn})
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(buckets)
+	Default.register(&histogramFamily{meta{name, help, "histogram"}, h})
+	return h
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) HistogramVec {
+	v := HistogramVec{newVec(meta{name, help, "histogram"}, labels, func() *Histogram { return newHistogram(buckets) })}
+	Default.register(v)
+	return v
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metric families and writes them out.
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]family
+}
+
+// Default is the registry the constructors add to and Handler serves.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+func (r *Registry) register(f family) {
+	name, _, _ := f.desc()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.families[name]; dup {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.families[name] = f
+}
+
+// WriteText writes every family in the Prometheus text format (version
+// 0.0.4), sorted by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		r.mu.RLock()
+		f := r.families[name]
+		r.mu.RUnlock()
+
+		_, help, typ := f.desc()
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, escape(help, false))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, typ)
+		f.series(func(suffix string, labels []label, value float64) {
+			bw.WriteString(name + suffix)
+			if len(labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, `%s="%s"`, l.name, escape(l.value, true))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatFloat(value))
+			bw.WriteByte('\n')
+		})
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the registry to a Prometheus scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+// Handler serves Default.
+func Handler() http.Handler {
+	return Default
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escape(s string, quoted bool) string {
+	if quoted {
+		return labelEscaper.Replace(s)
+	}
+	return helpEscaper.Replace(s)
+}
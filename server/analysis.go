@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"fourinrow/game"
+	"fourinrow/game/bot"
+)
+
+const (
+	MaxHintsPerGame     = 3
+	defaultAnalysisTime = 1 * time.Second
+	maxAnalysisTime     = 5 * time.Second
+)
+
+// AnalysisRequest describes a position as a board, a position string (see
+// game.PositionString) or the list of columns played from the empty board.
+// Moves win over a position, which wins over a board.
+type AnalysisRequest struct {
+	Board       *[6][7]int `json:"board,omitempty"`
+	Position    string     `json:"position,omitempty"`
+	Moves       []int      `json:"moves,omitempty"`
+	TimeLimitMs int        `json:"timeLimitMs,omitempty"`
+}
+
+type AnalysisResponse struct {
+	Position string  `json:"position"`
+	ToMove   int     `json:"toMove"`
+	BestMove int     `json:"bestMove"`
+	Score    int     `json:"score"`
+	Depth    int     `json:"depth"`
+	Scores   [7]*int `json:"scores"`
+	PV       []int   `json:"pv"`
+}
+
+// AnalysisHandler serves POST /analysis
+func AnalysisHandler(w http.ResponseWriter, r *http.Request) {
+	var req AnalysisRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var board [6][7]int
+	switch {
+	case req.Moves != nil:
+		g, err := game.Replay(req.Moves)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		board = g.Board
+	case req.Position != "":
+		b, _, err := game.ParsePosition(req.Position)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		board = b
+	case req.Board != nil:
+		board = *req.Board
+	default:
+		http.Error(w, "board or moves required", http.StatusBadRequest)
+		return
+	}
+
+	toMove, err := game.ValidateBoard(board)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if game.CheckWin(board, 1) || game.CheckWin(board, 2) {
+		http.Error(w, "game is already over", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultAnalysisTime
+	if req.TimeLimitMs > 0 {
+		limit = time.Duration(req.TimeLimitMs) * time.Millisecond
+	}
+	if limit > maxAnalysisTime {
+		limit = maxAnalysisTime
+	}
+
+	res := bot.Analyze(board, toMove, limit)
+	if res.Move < 0 {
+		http.Error(w, "no legal moves", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AnalysisResponse{
+		Position: game.PositionString(board),
+		ToMove:   toMove,
+		BestMove: res.Move,
+		Score:    res.Score,
+		Depth:    res.Depth,
+		Scores:   res.Scores,
+		PV:       res.PV,
+	})
+}
+
+// handleHint answers an in-game "hint" message with the engine's best move.
+func handleHint(g *game.Game, username string) {
+	player, ok := g.Players[username]
+	if !ok {
+		return
+	}
+
+	reply := func(msgType string, payload interface{}) {
+		player.Conn.WriteJSON(game.WSMessage{Type: msgType, Payload: payload})
+	}
+
+	switch {
+	case g.Rated:
+		reply("error", "Hints are disabled in rated games")
+		return
+	case g.Status != "playing" || g.CurrentTurn != player.ID:
+		reply("error", "You can only ask for a hint on your turn")
+		return
+	case g.HintsUsed[username] >= MaxHintsPerGame:
+		reply("error", "No hints left for this game")
+		return
+	}
+
+	col, err := bot.GetBestMove(g, player.Color)
+	if err != nil {
+		reply("error", err.Error())
+		return
+	}
+
+	if g.HintsUsed == nil {
+		g.HintsUsed = make(map[string]int)
+	}
+	g.HintsUsed[username]++
+	log.Printf("[HINT] %s used hint %d/%d in game %s", username, g.HintsUsed[username], MaxHintsPerGame, g.ID)
+
+	reply("hint", map[string]interface{}{
+		"column":    col,
+		"remaining": MaxHintsPerGame - g.HintsUsed[username],
+	})
+}
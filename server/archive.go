@@ -0,0 +1,217 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"fourinrow/db"
+	"fourinrow/game"
+
+	"github.com/google/uuid"
+)
+
+const maxImportBytes = 10 << 20
+
+// AdminToken guards POST /games/import, sent as "Authorization: Bearer
+// <token>". Imports are refused while it is empty.
+var AdminToken string
+
+func isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) == 1
+}
+
+// archiveFor converts a stored game to archive notation.
+func archiveFor(rec *db.GameRecord, moves []db.MoveRecord) *game.Archive {
+	kind := func(bot bool) string {
+		if bot {
+			return "bot"
+		}
+		return "human"
+	}
+	result := game.ResultDraw
+	switch rec.Winner {
+	case rec.Player1:
+		result = game.ResultRed
+	case rec.Player2:
+		result = game.ResultYellow
+	}
+
+	a := &game.Archive{Result: result}
+	a.Set("Game", rec.ID)
+	a.SetFinished(rec.FinishedAt)
+	a.Set("Red", rec.Player1)
+	a.Set("Yellow", rec.Player2)
+	a.Set("RedType", kind(rec.Player1IsBot))
+	a.Set("YellowType", kind(rec.Player2IsBot))
+	a.Set("Rules", game.ArchiveRules)
+	a.Set("Result", result)
+	if rec.FinishReason != "" {
+		a.Set("Termination", rec.FinishReason)
+	}
+	for _, m := range moves {
+		a.Moves = append(a.Moves, m.Column)
+	}
+	return a
+}
+
+func loadArchive(id string) (*game.Archive, error) {
+	rec, err := db.Repo.GetGame(id)
+	if rec == nil || err != nil {
+		return nil, err
+	}
+	moves, err := db.Repo.GetMoves(id)
+	if err != nil {
+		return nil, err
+	}
+	return archiveFor(rec, moves), nil
+}
+
+// GameArchiveHandler serves GET /games/{id}.txt
+func GameArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(r.PathValue("file"), ".txt")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	a, err := loadArchive(id)
+	if err != nil {
+		http.Error(w, "Failed to load game", http.StatusInternalServerError)
+		return
+	}
+	if a == nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	a.WriteTo(w)
+}
+
+// parseDay accepts RFC 3339 or a bare YYYY-MM-DD date.
+func parseDay(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// ExportHandler serves GET /games/export?player=&since=&until= as a stream of
+// archives, oldest first.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since, err1 := parseDay(q.Get("since"))
+	until, err2 := parseDay(q.Get("until"))
+	if err := errors.Join(err1, err2); err != nil {
+		http.Error(w, "since/until must be YYYY-MM-DD or RFC 3339", http.StatusBadRequest)
+		return
+	}
+
+	games, err := db.Repo.ListGames(db.GameFilter{Player: q.Get("player"), Since: since, Until: until})
+	if err != nil {
+		http.Error(w, "Failed to list games", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="games.txt"`)
+	flusher, _ := w.(http.Flusher)
+
+	for i := len(games) - 1; i >= 0; i-- {
+		if r.Context().Err() != nil {
+			return
+		}
+		moves, err := db.Repo.GetMoves(games[i].ID)
+		if err != nil {
+			// Headers are already sent, so all we can do is stop
+			log.Printf("[DB ERROR] Export stopped at game %s: %v", games[i].ID, err)
+			return
+		}
+		if _, err := archiveFor(&games[i], moves).WriteTo(w); err != nil {
+			return
+		}
+		if flusher != nil && i%50 == 0 {
+			flusher.Flush()
+		}
+	}
+}
+
+type ImportResult struct {
+	Imported []string      `json:"imported"`
+	Skipped  []string      `json:"skipped"` // already in the database
+	Errors   []ImportError `json:"errors"`
+}
+
+type ImportError struct {
+	Index int    `json:"index"` // 1-based position in the upload
+	Game  string `json:"game,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportHandler serves POST /games/import to admins. The body is one or more
+// archives. Every game is replayed through game.ApplyMove before it is
+// stored; imported games are kept apart and never count in player stats.
+func ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Admin token required", http.StatusUnauthorized)
+		return
+	}
+	res := ImportResult{Imported: []string{}, Skipped: []string{}, Errors: []ImportError{}}
+	reader := game.NewArchiveReader(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	for i := 1; ; i++ {
+		a, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The stream can't be resynchronised after a syntax error
+			res.Errors = append(res.Errors, ImportError{Index: i, Error: err.Error()})
+			break
+		}
+
+		if err := importArchive(a, &res); err != nil {
+			res.Errors = append(res.Errors, ImportError{Index: i, Game: a.Get("Game"), Error: err.Error()})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func importArchive(a *game.Archive, res *ImportResult) error {
+	g, err := a.Game()
+	if err != nil {
+		return err
+	}
+	if g.Status != "finished" {
+		return errors.New("only finished games can be imported")
+	}
+	if g.FinishedAt.IsZero() {
+		return errors.New("missing or invalid Date header")
+	}
+
+	if g.ID == "" {
+		g.ID = uuid.New().String()
+	} else if existing, err := db.Repo.GetGame(g.ID); err != nil {
+		return err
+	} else if existing != nil {
+		res.Skipped = append(res.Skipped, g.ID)
+		return nil
+	}
+
+	if err := db.Repo.ImportGame(g); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	res.Imported = append(res.Imported, g.ID)
+	return nil
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"fourinrow/cluster"
+	"fourinrow/game"
+
+	"github.com/gorilla/websocket"
+)
+
+// Cluster is shared by every node; NodeID names this one. The defaults make
+// a cluster of one.
+var (
+	Cluster cluster.Backend = cluster.NewLocal()
+	NodeID                  = "local"
+)
+
+const clusterTimeout = 2 * time.Second
+
+// Envelope is a message from one node to another.
+type Envelope struct {
+	Type     string          `json:"type"`
+	From     string          `json:"from"` // sending node
+	Username string          `json:"username"`
+	GameID   string          `json:"gameId,omitempty"`
+	Message  json.RawMessage `json:"message,omitempty"` // a WSMessage
+}
+
+const (
+	envDeliver = "deliver" // owner -> player's node: write Message to the socket
+	envMatched = "matched" // owner -> waiting player's node: they have a game
+	envEnded   = "ended"   // owner -> player's node: the game is over
+	envInput   = "input"   // player's node -> owner: a move or hint from the socket
+	envAttach  = "attach"  // player's node -> owner: they reconnected here
+	envDetach  = "detach"  // player's node -> owner: their socket closed
+)
+
+func publish(node string, env Envelope) {
+	env.From = NodeID
+	data, err := json.Marshal(env)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	if err := Cluster.Publish(ctx, cluster.NodeChannel(node), data); err != nil {
+		log.Printf("[CLUSTER] Failed to publish %s to %s: %v", env.Type, node, err)
+	}
+}
+
+// remoteConn stands in for the socket of a player connected to another node.
+type remoteConn struct {
+	node     string
+	username string
+}
+
+func (rc *remoteConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	publish(rc.node, Envelope{Type: envDeliver, Username: rc.username, Message: data})
+	return nil
+}
+
+// remoteSession is a player connected to this node whose game lives on
+// another node.
+type remoteSession struct {
+	route cluster.Route
+	conn  *websocket.Conn
+}
+
+var remote = struct {
+	mu       sync.Mutex
+	sessions map[string]*remoteSession // by username
+}{sessions: make(map[string]*remoteSession)}
+
+func remoteSessionFor(username string) *remoteSession {
+	remote.mu.Lock()
+	defer remote.mu.Unlock()
+	return remote.sessions[username]
+}
+
+func setRemoteSession(username string, s *remoteSession) {
+	remote.mu.Lock()
+	defer remote.mu.Unlock()
+	if s == nil {
+		delete(remote.sessions, username)
+		return
+	}
+	remote.sessions[username] = s
+}
+
+// registerGame records this node as the game's owner.
+func registerGame(g *game.Game) {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	if err := Cluster.PutGame(ctx, cluster.Route{GameID: g.ID, Owner: NodeID}, humanNames(g)); err != nil {
+		log.Printf("[CLUSTER] Failed to register game %s: %v", g.ID, err)
+	}
+
+	// Tell the other node before anything is delivered to its player
+	for _, p := range g.Players {
+		if rc, ok := p.Conn.(*remoteConn); ok {
+			publish(rc.node, Envelope{Type: envMatched, Username: p.Username, GameID: g.ID})
+		}
+	}
+}
+
+func unregisterGame(g *game.Game) {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	if err := Cluster.DeleteGame(ctx, g.ID, humanNames(g)); err != nil {
+		log.Printf("[CLUSTER] Failed to unregister game %s: %v", g.ID, err)
+	}
+	for _, p := range g.Players {
+		if rc, ok := p.Conn.(*remoteConn); ok {
+			publish(rc.node, Envelope{Type: envEnded, Username: p.Username, GameID: g.ID})
+		}
+	}
+}
+
+func humanNames(g *game.Game) []string {
+	var names []string
+	for _, p := range g.Players {
+		if !p.IsBot {
+			names = append(names, p.Username)
+		}
+	}
+	return names
+}
+
+// StartCluster subscribes this node to its channel. It must run before the
+// server accepts connections.
+func StartCluster() error {
+	msgs, err := Cluster.Subscribe(context.Background(), cluster.NodeChannel(NodeID))
+	if err != nil {
+		return err
+	}
+	go func() {
+		for data := range msgs {
+			var env Envelope
+			if err := json.Unmarshal(data, &env); err != nil {
+				log.Printf("[CLUSTER] Bad envelope: %v", err)
+				continue
+			}
+			handleEnvelope(env)
+		}
+	}()
+	return nil
+}
+
+// handleEnvelope runs envelopes one at a time, in the order they were sent.
+func handleEnvelope(env Envelope) {
+	switch env.Type {
+	case envDeliver:
+		if s := remoteSessionFor(env.Username); s != nil {
+			s.conn.WriteMessage(websocket.TextMessage, env.Message)
+		}
+
+	case envMatched:
+		GlobalMatchmaker.matchedRemotely(env.Username, cluster.Route{GameID: env.GameID, Owner: env.From})
+
+	case envEnded:
+		if s := remoteSessionFor(env.Username); s != nil && s.route.GameID == env.GameID {
+			setRemoteSession(env.Username, nil)
+		}
+
+	case envInput:
+		var msg game.WSMessage
+		if err := json.Unmarshal(env.Message, &msg); err != nil {
+			return
+		}
+		if g := game.Store.FindGameByPlayerName(env.Username); g != nil && g.ID == env.GameID {
+			dispatch(g, env.Username, msg)
+		}
+
+	case envAttach:
+		if g := game.Store.FindGameByPlayerName(env.Username); g != nil && g.ID == env.GameID {
+			GlobalMatchmaker.mu.Lock()
+			reattach(g, env.Username, &remoteConn{node: env.From, username: env.Username})
+			GlobalMatchmaker.mu.Unlock()
+		}
+
+	case envDetach:
+		g := game.Store.FindGameByPlayerName(env.Username)
+		if g == nil || g.ID != env.GameID {
+			return
+		}
+		// Ignore a stale socket if the player has since moved to another node
+		if rc, ok := g.Players[env.Username].Conn.(*remoteConn); ok && rc.node == env.From {
+			handleDisconnect(env.Username)
+		}
+	}
+}
+
+// attachRemote connects a player to their game on another node, if they have
+// one. It reports whether they did.
+func attachRemote(username string, conn *websocket.Conn) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	route, err := Cluster.FindByPlayer(ctx, username)
+	if err != nil {
+		log.Printf("[CLUSTER] Failed to look up %s: %v", username, err)
+		return false
+	}
+	if route == nil || route.Owner == NodeID {
+		return false
+	}
+
+	log.Printf("[CLUSTER] Reconnecting %s to game %s on node %s", username, route.GameID, route.Owner)
+	setRemoteSession(username, &remoteSession{route: *route, conn: conn})
+	publish(route.Owner, Envelope{Type: envAttach, Username: username, GameID: route.GameID})
+	return true
+}
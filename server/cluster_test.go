@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"fourinrow/analytics"
+	"fourinrow/cluster"
+	"fourinrow/db"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+)
+
+// Nodes keep their state in package variables, so each node of the cluster
+// test is this test binary run again with FOURINROW_TEST_NODE set.
+func TestMain(m *testing.M) {
+	if node := os.Getenv("FOURINROW_TEST_NODE"); node != "" {
+		runTestNode(node, os.Getenv("FOURINROW_TEST_CLUSTER"))
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runTestNode serves /ws on a free port, prints the address and runs until
+// it is killed.
+func runTestNode(node, clusterURL string) {
+	NodeID = node
+	backend, err := cluster.Open(clusterURL)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	Cluster = backend
+	db.Repo = db.NewMemoryStore()
+	analytics.Producer = analytics.NewStubProducer()
+	if err := StartCluster(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(l.Addr())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", WebSocketHandler)
+	http.Serve(l, mux)
+}
+
+func startTestNode(t *testing.T, node, clusterURL string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), "FOURINROW_TEST_NODE="+node, "FOURINROW_TEST_CLUSTER="+clusterURL)
+	var logs bytes.Buffer
+	cmd.Stderr = &logs
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if t.Failed() {
+			t.Logf("%s log:\n%s", node, logs.String())
+		}
+	})
+
+	addr := make(chan string, 1)
+	go func() {
+		lines := bufio.NewScanner(out)
+		if lines.Scan() {
+			addr <- lines.Text()
+		}
+		close(addr)
+	}()
+	select {
+	case a, ok := <-addr:
+		if !ok {
+			t.Fatalf("node %s exited before listening", node)
+		}
+		return a
+	case <-time.After(10 * time.Second):
+		t.Fatalf("node %s did not start", node)
+		return ""
+	}
+}
+
+type testMessage struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type testState struct {
+	Status string `json:"status"`
+	Moves  []struct {
+		Column int `json:"column"`
+	} `json:"moves"`
+}
+
+// testClient is a player's socket with a background reader.
+type testClient struct {
+	t    *testing.T
+	name string
+	conn *websocket.Conn
+	msgs chan testMessage
+}
+
+func dialTestNode(t *testing.T, addr, name string) *testClient {
+	t.Helper()
+	u := fmt.Sprintf("ws://%s/ws?username=%s", addr, url.QueryEscape(name))
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &testClient{t: t, name: name, conn: conn, msgs: make(chan testMessage, 64)}
+	go func() {
+		defer close(c.msgs)
+		for {
+			var m testMessage
+			if err := conn.ReadJSON(&m); err != nil {
+				return
+			}
+			c.msgs <- m
+		}
+	}()
+	return c
+}
+
+// await returns the next message of the given type, skipping others.
+func (c *testClient) await(typ string) testMessage {
+	c.t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case m, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatalf("%s: connection closed waiting for %q", c.name, typ)
+			}
+			if m.Type == typ {
+				return m
+			}
+		case <-deadline:
+			c.t.Fatalf("%s: timed out waiting for %q", c.name, typ)
+		}
+	}
+}
+
+// awaitState waits for an update with at least the given number of moves.
+func (c *testClient) awaitState(moves int) testState {
+	c.t.Helper()
+	for {
+		var g testState
+		json.Unmarshal(c.await("update").Payload, &g)
+		if len(g.Moves) >= moves {
+			return g
+		}
+	}
+}
+
+func (c *testClient) move(col int) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(map[string]interface{}{"type": "move", "payload": map[string]int{"column": col}}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// TestCrossNodeGame has alice on node A wait, bob on node B join and get
+// matched with her, then plays alice to a vertical win while bob hops from
+// node B to node A.
+func TestCrossNodeGame(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts two server processes")
+	}
+	mr := miniredis.RunT(t)
+	clusterURL := "redis://" + mr.Addr()
+	nodeA := startTestNode(t, "node-a", clusterURL)
+	nodeB := startTestNode(t, "node-b", clusterURL)
+
+	alice := dialTestNode(t, nodeA, "alice")
+	alice.await("waiting")
+	// "waiting" goes out before the ticket is queued
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if q, _ := mr.List("fourinrow:queue"); len(q) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("alice was never queued")
+		}
+	}
+	bob := dialTestNode(t, nodeB, "bob")
+	alice.await("start")
+	bob.await("start")
+
+	// alice waited longer, so she moves first
+	plies := 0
+	play := func(c *testClient, col int) {
+		t.Helper()
+		c.move(col)
+		plies++
+		alice.awaitState(plies)
+		bob.awaitState(plies)
+	}
+	play(alice, 0)
+	play(bob, 1)
+
+	// bob drops and comes back on the other node
+	bob.conn.Close()
+	time.Sleep(300 * time.Millisecond)
+	bob = dialTestNode(t, nodeA, "bob")
+	bob.await("start")
+
+	play(alice, 0)
+	play(bob, 1)
+	play(alice, 0)
+	play(bob, 1)
+
+	alice.move(0)
+	for _, c := range []*testClient{alice, bob} {
+		if g := c.awaitState(plies + 1); g.Status != "finished" {
+			t.Fatalf("%s sees status %q after the winning move", c.name, g.Status)
+		}
+	}
+}
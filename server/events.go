@@ -0,0 +1,150 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"fourinrow/events"
+	"fourinrow/game"
+)
+
+func playerRef(p *game.Player) events.PlayerRef {
+	return events.PlayerRef{PlayerID: p.ID, Username: p.Username, Bot: p.IsBot}
+}
+
+// playerEvent is an event about one player, in a game or (with g nil) in the
+// queue.
+func playerEvent(typ string, g *game.Game, p *game.Player, payload interface{}) events.Event {
+	e := events.Event{
+		Type:      typ,
+		PlayerID:  p.ID,
+		Username:  p.Username,
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	}
+	if g != nil {
+		e.GameID = g.ID
+	}
+	return e
+}
+
+func gameMode(g *game.Game) string {
+	for _, p := range g.Players {
+		if p.IsBot {
+			return events.ModePvE
+		}
+	}
+	return events.ModePvP
+}
+
+// playerRefs lists a game's players in colour order.
+func playerRefs(g *game.Game) []events.PlayerRef {
+	players := make([]*game.Player, 0, len(g.Players))
+	for _, p := range g.Players {
+		players = append(players, p)
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].Color < players[j].Color })
+
+	refs := make([]events.PlayerRef, len(players))
+	for i, p := range players {
+		refs[i] = playerRef(p)
+	}
+	return refs
+}
+
+func playerByID(g *game.Game, id string) *game.Player {
+	for _, p := range g.Players {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func gameStartedEvent(g *game.Game) events.Event {
+	return events.Event{
+		Type:      events.GameStarted,
+		GameID:    g.ID,
+		Timestamp: g.CreatedAt.Unix(),
+		Payload: events.GameStartedPayload{
+			Mode:    gameMode(g),
+			Rated:   g.Rated,
+			Players: playerRefs(g),
+		},
+	}
+}
+
+// moveEvent describes the game's last move.
+func moveEvent(g *game.Game) events.Event {
+	ply := len(g.Moves)
+	m := g.Moves[ply-1]
+	since := g.CreatedAt
+	if ply > 1 {
+		since = g.Moves[ply-2].At
+	}
+
+	mover := playerByID(g, m.PlayerID)
+	if mover == nil {
+		mover = &game.Player{ID: m.PlayerID}
+	}
+	e := playerEvent(events.MoveMade, g, mover, events.MoveMadePayload{
+		Column:  m.Column,
+		Row:     m.Row,
+		Ply:     ply,
+		Color:   m.Color,
+		ThinkMs: m.At.Sub(since).Milliseconds(),
+		Bot:     mover.IsBot,
+	})
+	e.Timestamp = m.At.Unix()
+	return e
+}
+
+// finishEvents are the events for a game that has just ended: game_finished,
+// plus draw or game_abandoned when they apply.
+func finishEvents(g *game.Game) []events.Event {
+	now := time.Now()
+	mode := gameMode(g)
+	payload := events.GameFinishedPayload{
+		Mode:       mode,
+		Result:     events.ResultWin,
+		Reason:     g.FinishReason,
+		Moves:      len(g.Moves),
+		DurationMs: now.Sub(g.CreatedAt).Milliseconds(),
+		Rated:      g.Rated,
+	}
+	finished := events.Event{Type: events.GameFinished, GameID: g.ID, Timestamp: now.Unix()}
+	if winner := playerByID(g, g.Winner); winner != nil {
+		ref := playerRef(winner)
+		payload.Winner = &ref
+		finished.PlayerID, finished.Username = winner.ID, winner.Username
+	} else {
+		payload.Result = events.ResultDraw
+	}
+	finished.Payload = payload
+	out := []events.Event{finished}
+
+	if payload.Result == events.ResultDraw {
+		out = append(out, events.Event{
+			Type: events.Draw, GameID: g.ID, Timestamp: now.Unix(),
+			Payload: events.DrawPayload{Reason: g.FinishReason, Ply: len(g.Moves), Mode: mode, Players: playerRefs(g)},
+		})
+	}
+
+	if g.FinishReason == game.FinishDisconnect || g.FinishReason == game.FinishAdjudicated {
+		var gone []events.PlayerRef
+		for _, p := range playerRefs(g) {
+			if pl := g.Players[p.Username]; pl != nil && !pl.IsBot && !pl.IsConnected {
+				gone = append(gone, p)
+			}
+		}
+		abandoned := events.Event{
+			Type: events.GameAbandoned, GameID: g.ID, Timestamp: now.Unix(),
+			Payload: events.GameAbandonedPayload{AbandonedBy: gone, Reason: g.FinishReason, Ply: len(g.Moves)},
+		}
+		if len(gone) == 1 {
+			abandoned.PlayerID, abandoned.Username = gone[0].PlayerID, gone[0].Username
+		}
+		out = append(out, abandoned)
+	}
+	return out
+}
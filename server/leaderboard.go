@@ -1,18 +1,260 @@
 package server
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"fourinrow/db"
 )
 
+type Period string
+
+const (
+	PeriodDaily   Period = "daily"
+	PeriodWeekly  Period = "weekly"
+	PeriodMonthly Period = "monthly"
+	PeriodAllTime Period = "all-time"
+)
+
+var periods = []Period{PeriodDaily, PeriodWeekly, PeriodMonthly, PeriodAllTime}
+
+type Metric string
+
+const (
+	MetricRating  Metric = "rating"
+	MetricWins    Metric = "wins"
+	MetricWinRate Metric = "win_rate"
+	MetricStreak  Metric = "streak"
+)
+
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 100
+)
+
+// MinGamesForWinRate keeps players with a handful of lucky games off the win
+// rate board. Callers can raise it with ?min_games=.
+var MinGamesForWinRate = 10
+
+// since returns the start of the current calendar period in UTC.
+func (p Period) since(now time.Time) time.Time {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch p {
+	case PeriodDaily:
+		return day
+	case PeriodWeekly:
+		// Weeks start on Monday
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case PeriodMonthly:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Time{}
+}
+
+// leaderboardSnapshot holds every period's standings. Requests rank and page
+// from it, so the database only sees one query per period per refresh.
+type leaderboardSnapshot struct {
+	builtAt   time.Time
+	standings map[Period][]db.LeaderboardEntry
+}
+
+var leaderboard struct {
+	mu   sync.RWMutex
+	snap *leaderboardSnapshot
+}
+
+func refreshLeaderboard() error {
+	now := time.Now()
+	snap := &leaderboardSnapshot{builtAt: now, standings: make(map[Period][]db.LeaderboardEntry)}
+	for _, p := range periods {
+		entries, err := db.Repo.GetStandings(p.since(now))
+		if err != nil {
+			return err
+		}
+		snap.standings[p] = entries
+	}
+
+	leaderboard.mu.Lock()
+	leaderboard.snap = snap
+	leaderboard.mu.Unlock()
+	return nil
+}
+
+// StartLeaderboardRefresher rebuilds the leaderboard snapshot every interval.
+func StartLeaderboardRefresher(interval time.Duration) {
+	if err := refreshLeaderboard(); err != nil {
+		log.Printf("[LEADERBOARD] Refresh failed: %v", err)
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := refreshLeaderboard(); err != nil {
+				log.Printf("[LEADERBOARD] Refresh failed: %v", err)
+			}
+		}
+	}()
+}
+
+func currentSnapshot() (*leaderboardSnapshot, error) {
+	leaderboard.mu.RLock()
+	snap := leaderboard.snap
+	leaderboard.mu.RUnlock()
+	if snap != nil {
+		return snap, nil
+	}
+
+	// The refresher has not run yet
+	if err := refreshLeaderboard(); err != nil {
+		return nil, err
+	}
+	leaderboard.mu.RLock()
+	defer leaderboard.mu.RUnlock()
+	return leaderboard.snap, nil
+}
+
+// rank sorts a copy of the standings by metric and assigns competition ranks
+// (1, 2, 2, 4). Ties in the metric share a rank and are listed by name.
+func rank(entries []db.LeaderboardEntry, metric Metric, minGames int) []db.LeaderboardEntry {
+	value := func(e *db.LeaderboardEntry) float64 {
+		switch metric {
+		case MetricWins:
+			return float64(e.TotalWins)
+		case MetricWinRate:
+			return e.WinRate
+		case MetricStreak:
+			return float64(e.BestStreak)
+		}
+		return float64(e.Rating)
+	}
+
+	res := make([]db.LeaderboardEntry, 0, len(entries))
+	for _, e := range entries {
+		if metric == MetricWinRate && e.Games < minGames {
+			continue
+		}
+		res = append(res, e)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if a, b := value(&res[i]), value(&res[j]); a != b {
+			return a > b
+		}
+		return res[i].Username < res[j].Username
+	})
+	for i := range res {
+		res[i].Rank = i + 1
+		if i > 0 && value(&res[i]) == value(&res[i-1]) {
+			res[i].Rank = res[i-1].Rank
+		}
+	}
+	return res
+}
+
+type LeaderboardResponse struct {
+	Period     Period                `json:"period"`
+	Metric     Metric                `json:"metric"`
+	MinGames   int                   `json:"min_games,omitempty"`
+	UpdatedAt  time.Time             `json:"updated_at"`
+	Total      int                   `json:"total"`
+	Offset     int                   `json:"offset"`
+	Entries    []db.LeaderboardEntry `json:"entries"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+	Me         *db.LeaderboardEntry  `json:"me,omitempty"` // the ?me= player, if ranked
+}
+
+// The cursor is an opaque wrapper around the offset so clients don't build it
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(c string) (int, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(b))
+	return n, err == nil && n >= 0
+}
+
+// LeaderboardHandler serves GET /leaderboard?period=&metric=&limit=&offset=&cursor=&me=
 func LeaderboardHandler(w http.ResponseWriter, r *http.Request) {
-	if db.Repo == nil {
-		http.Error(w, "DB unavailable", 503)
+	q := r.URL.Query()
+	period := Period(q.Get("period"))
+	if period == "" {
+		period = PeriodAllTime
+	}
+	if period.since(time.Now()).IsZero() && period != PeriodAllTime {
+		http.Error(w, "Unknown period", http.StatusBadRequest)
+		return
+	}
+
+	metric := Metric(q.Get("metric"))
+	switch metric {
+	case "":
+		metric = MetricWins
+	case MetricRating, MetricWins, MetricWinRate, MetricStreak:
+	default:
+		http.Error(w, "Unknown metric", http.StatusBadRequest)
 		return
 	}
 
-	data, _ := db.Repo.GetLeaderboard()
-	json.NewEncoder(w).Encode(data)
-}
\ No newline at end of file
+	minGames := 0
+	if metric == MetricWinRate {
+		minGames = MinGamesForWinRate
+		if n, err := strconv.Atoi(q.Get("min_games")); err == nil && n > minGames {
+			minGames = n
+		}
+	}
+
+	limit := defaultLeaderboardLimit
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+		limit = min(n, maxLeaderboardLimit)
+	}
+	offset := 0
+	if c := q.Get("cursor"); c != "" {
+		n, ok := decodeCursor(c)
+		if !ok {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	} else if n, err := strconv.Atoi(q.Get("offset")); err == nil && n > 0 {
+		offset = n
+	}
+
+	snap, err := currentSnapshot()
+	if err != nil {
+		http.Error(w, "Failed to load leaderboard", http.StatusInternalServerError)
+		return
+	}
+
+	ranked := rank(snap.standings[period], metric, minGames)
+	resp := LeaderboardResponse{
+		Period: period, Metric: metric, MinGames: minGames, UpdatedAt: snap.builtAt,
+		Total: len(ranked), Offset: offset, Entries: []db.LeaderboardEntry{},
+	}
+	if offset < len(ranked) {
+		end := min(offset+limit, len(ranked))
+		resp.Entries = ranked[offset:end]
+		if end < len(ranked) {
+			resp.NextCursor = encodeCursor(end)
+		}
+	}
+	if me := q.Get("me"); me != "" {
+		for i := range ranked {
+			if ranked[i].Username == me {
+				resp.Me = &ranked[i]
+				break
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
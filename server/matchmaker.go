@@ -1,11 +1,13 @@
 package server
 
 import (
+	"context"
 	"log"
 	"sync"
 	"time"
 
-	"fourinrow/analytics"
+	"fourinrow/cluster"
+	"fourinrow/events"
 	"fourinrow/game"
 	"fourinrow/game/bot"
 
@@ -14,36 +16,33 @@ import (
 )
 
 type Matchmaker struct {
-	mu            sync.Mutex
-	pendingPlayer *game.Player
-	timer         *time.Timer
+	mu      sync.Mutex
+	waiting map[string]*waitingPlayer // players on this node in the shared queue
 }
 
-var GlobalMatchmaker = &Matchmaker{}
+type waitingPlayer struct {
+	player *game.Player
+	conn   *websocket.Conn
+	timer  *time.Timer
+	since  time.Time
+}
+
+var GlobalMatchmaker = &Matchmaker{waiting: make(map[string]*waitingPlayer)}
 
 const MatchmakingTimeout = 10 * time.Second
 
 func (m *Matchmaker) Join(username string, conn *websocket.Conn) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	log.Printf("[MATCHMAKER] Player joined: %s", username)
 
-	// 1. Reconnection Logic
+	// 1. Reconnection Logic, to a game here or on another node
 	if activeGame := game.Store.FindGameByPlayerName(username); activeGame != nil {
 		log.Printf("[MATCHMAKER] Reconnecting player %s to game %s", username, activeGame.ID)
-		player := activeGame.Players[username]
-		if player.DisconnectTimer != nil {
-			player.DisconnectTimer.Stop()
-			player.DisconnectTimer = nil
-		}
-		player.Conn = conn
-		player.IsConnected = true
-		
-		conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{
-			"gameId": activeGame.ID, "color": player.Color, "playerId": player.ID, "opponent": "Opponent", 
-		}})
-		conn.WriteJSON(game.WSMessage{Type: "update", Payload: activeGame})
+		m.mu.Lock()
+		reattach(activeGame, username, conn)
+		m.mu.Unlock()
+		return
+	}
+	if attachRemote(username, conn) {
 		return
 	}
 
@@ -53,79 +52,255 @@ func (m *Matchmaker) Join(username string, conn *websocket.Conn) {
 		Conn:        conn,
 		IsConnected: true,
 	}
+	joined := time.Now()
+	emit(playerEvent(events.MatchmakingJoined, nil, player, events.MatchmakingJoinedPayload{Node: NodeID}))
+
+	// Sent before queueing: once the ticket is in the queue another
+	// goroutine may start the game and write to the socket.
+	conn.WriteJSON(game.WSMessage{Type: "waiting", Payload: "Looking for opponent... (10s)"})
 
-	// 2. Prevent Self-Matching (React Strict Mode Fix)
-	if m.pendingPlayer != nil && m.pendingPlayer.Username == username {
+	// 2. Prevent Self-Matching (React Strict Mode Fix). The shared queue
+	// also drops the old ticket when we match below. We are listed before
+	// queueing so a local player who pops our ticket can find us; the
+	// cluster calls below run without m.mu so a slow queue stalls only us.
+	w := &waitingPlayer{player: player, conn: conn, since: joined}
+	m.mu.Lock()
+	if old := m.waiting[username]; old != nil {
 		log.Printf("[MATCHMAKER] Player %s rejoined (replacing pending connection)", username)
-		if m.timer != nil { m.timer.Stop() }
-		m.pendingPlayer = player
-		
-		conn.WriteJSON(game.WSMessage{Type: "waiting", Payload: "Looking for opponent... (10s)"})
-		m.timer = time.AfterFunc(MatchmakingTimeout, func() {
-			m.mu.Lock()
-			defer m.mu.Unlock()
-			if m.pendingPlayer == player {
-				log.Printf("[MATCHMAKER] Timeout reached for %s. Starting Bot Game.", player.Username)
-				m.pendingPlayer = nil 
-				m.StartBotGame(player)
-			}
-		})
-		return
+		old.stop()
 	}
+	m.waiting[username] = w
+	m.mu.Unlock()
+
+	// 3. PvP Match found, possibly with a player on another node
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+		opp, err := Cluster.Match(ctx, cluster.Ticket{Username: username, Node: NodeID, Since: joined})
+		cancel()
+		if err != nil {
+			log.Printf("[MATCHMAKER] Queue unavailable: %v", err)
+			break
+		}
+		if opp == nil {
+			break
+		}
 
-	// 3. PvP Match found
-	if m.pendingPlayer != nil {
-		log.Printf("[MATCHMAKER] PvP Match found: %s vs %s", m.pendingPlayer.Username, player.Username)
-		if m.timer != nil { m.timer.Stop() }
-		opponent := m.pendingPlayer
-		m.pendingPlayer = nil 
-		m.StartGame(opponent, player)
+		if opp.Node != NodeID {
+			log.Printf("[MATCHMAKER] PvP Match found: %s (node %s) vs %s", opp.Username, opp.Node, username)
+			m.remove(username, w)
+			remotePlayer := &game.Player{
+				ID:          uuid.New().String(),
+				Username:    opp.Username,
+				Conn:        &remoteConn{node: opp.Node, username: opp.Username},
+				IsConnected: true,
+			}
+			g := m.StartGame(remotePlayer, player)
+			emitMatched(g, remotePlayer, opp.Since, player, joined, true)
+			return
+		}
+
+		m.mu.Lock()
+		ow := m.waiting[opp.Username]
+		if ow != nil {
+			ow.stop()
+			delete(m.waiting, opp.Username)
+			if m.waiting[username] == w {
+				delete(m.waiting, username)
+			}
+		}
+		m.mu.Unlock()
+		if ow == nil {
+			continue // They left without the queue hearing about it
+		}
+		log.Printf("[MATCHMAKER] PvP Match found: %s vs %s", opp.Username, username)
+		g := m.StartGame(ow.player, player)
+		emitMatched(g, ow.player, ow.since, player, joined, false)
 		return
 	}
 
 	// 4. Wait for opponent
 	log.Printf("[MATCHMAKER] Player %s waiting for opponent...", username)
-	m.pendingPlayer = player
-	conn.WriteJSON(game.WSMessage{Type: "waiting", Payload: "Looking for opponent... (10s)"})
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.waiting[username] != w {
+		return // Matched, replaced or gone already
+	}
+	w.timer = time.AfterFunc(MatchmakingTimeout, func() { m.timeout(username, w) })
+}
 
-	m.timer = time.AfterFunc(MatchmakingTimeout, func() {
-		m.mu.Lock()
-		defer m.mu.Unlock()
-		if m.pendingPlayer == player {
-			log.Printf("[MATCHMAKER] Timeout reached for %s. Starting Bot Game.", player.Username)
-			m.pendingPlayer = nil 
-			m.StartBotGame(player)
-		}
-	})
+// timeout gives a player who waited too long a bot game.
+func (m *Matchmaker) timeout(username string, w *waitingPlayer) {
+	if !m.listed(username, w) {
+		return
+	}
+	// A node that matched us first will send "matched" instead
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	if left, err := Cluster.Leave(ctx, username); err == nil && !left {
+		return
+	}
+	if !m.remove(username, w) {
+		return
+	}
+	log.Printf("[MATCHMAKER] Timeout reached for %s. Starting Bot Game.", username)
+	g := m.StartBotGame(w.player)
+	matchmakingWait.With("bot").ObserveSince(w.since)
+	emit(playerEvent(events.MatchmakingTimeoutBot, g, w.player, events.MatchmakingTimeoutBotPayload{
+		WaitMs: time.Since(w.since).Milliseconds(), BotProfile: g.Players["cpu"].BotProfile,
+	}))
+}
+
+// stop cancels the bot timeout, which is unset until the player is queued.
+func (w *waitingPlayer) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
 }
 
-func (m *Matchmaker) StartGame(p1, p2 *game.Player) {
+// listed reports whether w is still the player's pending connection.
+func (m *Matchmaker) listed(username string, w *waitingPlayer) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.waiting[username] == w
+}
+
+// remove takes w off the waiting list and reports whether it was still on it.
+func (m *Matchmaker) remove(username string, w *waitingPlayer) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.waiting[username] != w {
+		return false
+	}
+	w.stop()
+	delete(m.waiting, username)
+	return true
+}
+
+// emitMatched records a PvP match for both players. The waiting player
+// may be connected to another node.
+func emitMatched(g *game.Game, waiting *game.Player, waitingSince time.Time, joiner *game.Player, joined time.Time, remote bool) {
+	now := time.Now()
+	matchmakingWait.With("matched").Observe(now.Sub(waitingSince).Seconds())
+	matchmakingWait.With("matched").Observe(now.Sub(joined).Seconds())
+	emit(playerEvent(events.MatchmakingMatched, g, waiting, events.MatchmakingMatchedPayload{
+		Opponent: playerRef(joiner), WaitMs: now.Sub(waitingSince).Milliseconds(), Remote: remote,
+	}))
+	emit(playerEvent(events.MatchmakingMatched, g, joiner, events.MatchmakingMatchedPayload{
+		Opponent: playerRef(waiting), WaitMs: now.Sub(joined).Milliseconds(), Remote: remote,
+	}))
+}
+
+// Leave drops a waiting player whose socket closed.
+func (m *Matchmaker) Leave(username string, conn *websocket.Conn) {
+	m.mu.Lock()
+	w := m.waiting[username]
+	if w == nil || w.conn != conn {
+		m.mu.Unlock()
+		return
+	}
+	w.stop()
+	delete(m.waiting, username)
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	Cluster.Leave(ctx, username)
+}
+
+// QueueLength counts the players waiting on this node.
+func (m *Matchmaker) QueueLength() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.waiting)
+}
+
+// matchedRemotely hands a waiting player over to a game on another node.
+func (m *Matchmaker) matchedRemotely(username string, route cluster.Route) {
+	m.mu.Lock()
+	w := m.waiting[username]
+	if w != nil {
+		w.stop()
+		delete(m.waiting, username)
+	}
+	m.mu.Unlock()
+	if w == nil {
+		// Gone before the match landed: let the owner run the disconnect timer
+		publish(route.Owner, Envelope{Type: envDetach, Username: username, GameID: route.GameID})
+		return
+	}
+	log.Printf("[MATCHMAKER] %s matched into game %s on node %s", username, route.GameID, route.Owner)
+	setRemoteSession(username, &remoteSession{route: route, conn: w.conn})
+}
+
+// reattach gives a returning player their seat back. The caller holds m.mu.
+func reattach(g *game.Game, username string, conn game.Conn) {
+	player := g.Players[username]
+	if player.DisconnectTimer != nil {
+		player.DisconnectTimer.Stop()
+		player.DisconnectTimer = nil
+	}
+	player.Conn = conn
+	player.IsConnected = true
+
+	payload := events.PlayerReconnectedPayload{Node: NodeID}
+	if rc, ok := conn.(*remoteConn); ok {
+		payload.Node = rc.node
+	}
+	if !player.DisconnectedAt.IsZero() {
+		payload.AwayMs = time.Since(player.DisconnectedAt).Milliseconds()
+		player.DisconnectedAt = time.Time{}
+	}
+	emit(playerEvent(events.PlayerReconnected, g, player, payload))
+
+	conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{
+		"gameId": g.ID, "color": player.Color, "playerId": player.ID, "opponent": "Opponent",
+	}})
+	conn.WriteJSON(game.WSMessage{Type: "update", Payload: g})
+}
+
+func (m *Matchmaker) StartGame(p1, p2 *game.Player) *game.Game {
 	gameID := uuid.New().String()
 	newGame := &game.Game{
 		ID: gameID, Players: make(map[string]*game.Player),
 		Status: "playing", CurrentTurn: p1.ID, CreatedAt: time.Now(),
+		Rated: true, // PvP games count, so no engine help
 	}
 	p1.Color = 1; p1.GameID = gameID
 	p2.Color = 2; p2.GameID = gameID
 	newGame.Players[p1.Username] = p1
 	newGame.Players[p2.Username] = p2
 	game.Store.AddGame(newGame)
+	registerGame(newGame)
+	snapshotMove(newGame)
 
 	// Send Start Signal
-	p1.Conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{"gameId": gameID, "color": 1, "playerId": p1.ID, "opponent": p2.Username}})
-	p2.Conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{"gameId": gameID, "color": 2, "playerId": p2.ID, "opponent": p1.Username}})
+	start1 := map[string]interface{}{"gameId": gameID, "color": 1, "playerId": p1.ID, "opponent": p2.Username}
+	start2 := map[string]interface{}{"gameId": gameID, "color": 2, "playerId": p2.ID, "opponent": p1.Username}
+	// Rematches get the rivalry so far
+	if h2h := previousMeetings(p1.Username, p2.Username); h2h != nil {
+		start1["headToHead"] = h2h.For(p1.Username)
+		start2["headToHead"] = h2h.For(p2.Username)
+	}
+	p1.Conn.WriteJSON(game.WSMessage{Type: "start", Payload: start1})
+	p2.Conn.WriteJSON(game.WSMessage{Type: "start", Payload: start2})
 	
 	// --- FIX: Send Initial Board State ---
 	p1.Conn.WriteJSON(game.WSMessage{Type: "update", Payload: newGame})
 	p2.Conn.WriteJSON(game.WSMessage{Type: "update", Payload: newGame})
 	// -------------------------------------
 
-	analytics.Producer.Emit(analytics.GameEvent{Type: "game_started", GameID: gameID, Payload: "PvP"})
+	gamesStarted.With(gameMode(newGame)).Inc()
+	emit(gameStartedEvent(newGame))
+	return newGame
 }
 
-func (m *Matchmaker) StartBotGame(p1 *game.Player) {
+func (m *Matchmaker) StartBotGame(p1 *game.Player) *game.Game {
 	gameID := uuid.New().String()
-	botPlayer := &game.Player{ID: "cpu", Username: "Bot 🤖", Color: 2, IsBot: true, IsConnected: true, GameID: gameID}
+	profile := bot.RandomProfile()
+	botPlayer := &game.Player{
+		ID: "cpu", Username: profile.Username(), Color: 2, IsBot: true, IsConnected: true, GameID: gameID,
+		BotProfile: profile.Key, Avatar: profile.Avatar,
+	}
 
 	newGame := &game.Game{
 		ID: gameID, Players: make(map[string]*game.Player),
@@ -136,11 +311,15 @@ func (m *Matchmaker) StartBotGame(p1 *game.Player) {
 	newGame.Players["cpu"] = botPlayer 
 
 	game.Store.AddGame(newGame)
+	registerGame(newGame)
+	snapshotMove(newGame)
 	
 	log.Printf("[MATCHMAKER] Sending start message to %s for Game %s", p1.Username, gameID)
 	
 	// Send Start Signal
-	err := p1.Conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{"gameId": gameID, "color": 1, "playerId": p1.ID, "opponent": "Bot 🤖"}})
+	err := p1.Conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{
+		"gameId": gameID, "color": 1, "playerId": p1.ID, "opponent": profile.DisplayName, "opponentAvatar": profile.Avatar,
+	}})
 	if err != nil {
 		log.Printf("[ERROR] Failed to send start message: %v", err)
 	}
@@ -149,7 +328,9 @@ func (m *Matchmaker) StartBotGame(p1 *game.Player) {
 	p1.Conn.WriteJSON(game.WSMessage{Type: "update", Payload: newGame})
 	// -------------------------------------
 
-    analytics.Producer.Emit(analytics.GameEvent{Type: "game_started", GameID: gameID, Payload: "PvE"})
+    gamesStarted.With(gameMode(newGame)).Inc()
+    emit(gameStartedEvent(newGame))
+    return newGame
 }
 
 // HandleMove processes the move synchronously
@@ -159,25 +340,44 @@ func HandleMove(g *game.Game, playerUsername string, col int) {
     
     // 1. Human Move
     if err := game.ApplyMove(g, player.ID, col); err != nil {
+        invalidMoves.With(errorLabel(err)).Inc()
         player.Conn.WriteJSON(game.WSMessage{Type: "error", Payload: err.Error()})
         return
     }
+    movesPlayed.With("human").Inc()
+    emit(moveEvent(g))
     BroadcastState(g)
     if g.Status == "finished" { HandleGameOver(g); return }
+    snapshotMove(g)
 
     // 2. Bot Move (Synchronous)
     if g.CurrentTurn == "cpu" {
-        time.Sleep(500 * time.Millisecond) // Think time
-
-        botCol, err := bot.GetBestMove(g, 2)
-        if err != nil {
-            botCol = 0 // Fallback
-        }
-        
-        game.ApplyMove(g, "cpu", botCol)
-        BroadcastState(g)
-        if g.Status == "finished" {
-            HandleGameOver(g)
-        }
+        playBotTurn(g)
+    }
+}
+
+// playBotTurn makes the bot's move. It is also used to resume a restored bot
+// game that was waiting on the bot.
+func playBotTurn(g *game.Game) {
+    botPlayer := g.Players["cpu"]
+    profile := bot.ProfileByKey(botPlayer.BotProfile)
+
+    botCol := 0 // Fallback
+    start := time.Now()
+    if d, err := profile.Decide(g, botPlayer.Color); err == nil {
+        time.Sleep(d.Think) // Think time depends on how hard the position is
+        botCol = d.Column
+    }
+    botThink.With(botPlayer.BotProfile).ObserveSince(start)
+
+    if err := game.ApplyMove(g, "cpu", botCol); err == nil {
+        movesPlayed.With("bot").Inc()
+        emit(moveEvent(g))
+    }
+    BroadcastState(g)
+    if g.Status == "finished" {
+        HandleGameOver(g)
+        return
     }
+    snapshotMove(g)
 }
\ No newline at end of file
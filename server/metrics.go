@@ -0,0 +1,77 @@
+package server
+
+import (
+	"strings"
+
+	"fourinrow/events"
+	"fourinrow/game"
+	"fourinrow/game/bot"
+	"fourinrow/metrics"
+)
+
+// Server metrics, served on /metrics
+var (
+	connectedSockets = metrics.NewGauge("fourinrow_connected_sockets",
+		"Open WebSocket connections on this node.")
+
+	gamesStarted = metrics.NewCounterVec("fourinrow_games_started_total",
+		"Games started on this node, by mode.", "mode")
+	gamesFinished = metrics.NewCounterVec("fourinrow_games_finished_total",
+		"Games finished on this node, by mode and result.", "mode", "result")
+	movesPlayed = metrics.NewCounterVec("fourinrow_moves_total",
+		"Moves played, by who made them (human or bot).", "by")
+	invalidMoves = metrics.NewCounterVec("fourinrow_invalid_moves_total",
+		"Moves rejected, by error.", "error")
+	disconnects = metrics.NewCounter("fourinrow_disconnects_total",
+		"Players who dropped out of a game in progress.")
+
+	matchmakingWait = metrics.NewHistogramVec("fourinrow_matchmaking_wait_seconds",
+		"Time in the queue before a match, by outcome (matched or bot).",
+		[]float64{.1, .25, .5, 1, 2, 3, 5, 7.5, 10, 15, 30, 60}, "outcome")
+	botThink = metrics.NewHistogramVec("fourinrow_bot_think_seconds",
+		"Time the bot takes to move, searching and pausing, by profile.",
+		metrics.ExponentialBuckets(.05, 2, 9), "profile")
+)
+
+func init() {
+	metrics.NewGaugeFunc("fourinrow_live_games", "Games in progress on this node.", func() float64 {
+		return float64(game.Store.Stats().Live)
+	})
+	metrics.NewGaugeFunc("fourinrow_matchmaking_queue_length", "Players waiting in the queue on this node.", func() float64 {
+		return float64(GlobalMatchmaker.QueueLength())
+	})
+
+	// Summed over the live transposition tables. Released isolated tables
+	// take their counts with them, so these are gauges.
+	metrics.NewGaugeFunc("fourinrow_bot_tt_entries", "Slots in the bot's live transposition tables.", func() float64 {
+		return float64(bot.Stats().Entries)
+	})
+	metrics.NewGaugeFunc("fourinrow_bot_tt_probes", "Transposition table lookups.", func() float64 {
+		return float64(bot.Stats().Probes)
+	})
+	metrics.NewGaugeFunc("fourinrow_bot_tt_hits", "Transposition table lookups that found the position.", func() float64 {
+		return float64(bot.Stats().Hits)
+	})
+	metrics.NewGaugeFunc("fourinrow_bot_tt_stores", "Positions written to the transposition tables.", func() float64 {
+		return float64(bot.Stats().Stores)
+	})
+	metrics.NewGaugeFunc("fourinrow_bot_tt_replaced", "Stores that overwrote a different position.", func() float64 {
+		return float64(bot.Stats().Replaced)
+	})
+	metrics.NewGaugeFunc("fourinrow_bot_tt_rejected", "Stores dropped because the slot held a deeper entry.", func() float64 {
+		return float64(bot.Stats().Rejected)
+	})
+}
+
+// gameResult is the game's result label: win or draw.
+func gameResult(g *game.Game) string {
+	if playerByID(g, g.Winner) != nil {
+		return events.ResultWin
+	}
+	return events.ResultDraw
+}
+
+// errorLabel turns a move error into a label value, e.g. "column_is_full".
+func errorLabel(err error) string {
+	return strings.ReplaceAll(err.Error(), " ", "_")
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"fourinrow/analytics"
+	"fourinrow/db"
+	"fourinrow/events"
+)
+
+const (
+	outboxBatch     = 100
+	outboxLease     = 30 * time.Second // how long a claimed event is hidden from other relays
+	outboxRetention = 24 * time.Hour   // sent events stay in the database this long for debugging
+	outboxMaxDelay  = 5 * time.Minute
+)
+
+// OutboxPollInterval is how often the relay looks for due events when it
+// has not been woken by a new one.
+var OutboxPollInterval = time.Second
+
+var outboxWake = make(chan struct{}, 1)
+
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+func outboxEvent(e events.Event) (db.OutboxEvent, error) {
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().Unix()
+	}
+	payload, err := events.Marshal(e)
+	if err != nil {
+		return db.OutboxEvent{}, err
+	}
+	return db.OutboxEvent{Type: e.Type, Key: e.Key(), Payload: payload}, nil
+}
+
+// emit queues an analytics event in the outbox, so it survives Kafka outages
+// and restarts.
+func emit(e events.Event) {
+	queued, err := outboxEvent(e)
+	if err != nil {
+		log.Printf("[OUTBOX] Dropping %s event: %v", e.Type, err)
+		return
+	}
+	if err := db.Repo.AddOutbox(queued); err != nil {
+		log.Printf("[OUTBOX] Failed to queue %s event, sending directly: %v", e.Type, err)
+		analytics.Producer.Emit(e)
+		return
+	}
+	wakeOutbox()
+}
+
+// StartOutboxRelay publishes outbox events to the analytics producer. Events
+// are marked sent only after the producer accepts them, so delivery is
+// at-least-once: a crash between the two resends the event.
+func StartOutboxRelay() {
+	go func() {
+		poll := time.NewTicker(OutboxPollInterval)
+		prune := time.NewTicker(time.Hour)
+		defer poll.Stop()
+		defer prune.Stop()
+		for {
+			select {
+			case <-poll.C:
+			case <-outboxWake:
+			case <-prune.C:
+				if n, err := db.Repo.PruneOutbox(time.Now().Add(-outboxRetention)); err != nil {
+					log.Printf("[OUTBOX] Prune failed: %v", err)
+				} else if n > 0 {
+					log.Printf("[OUTBOX] Pruned %d sent events", n)
+				}
+				continue
+			}
+			// Keep going while there are full batches
+			for relayOutbox() == outboxBatch {
+			}
+		}
+	}()
+}
+
+// relayOutbox sends one batch. Each failed event backs off on its own, so
+// one bad event doesn't hold up the rest. It returns how many events were
+// claimed, or 0 if any failed so the caller stops draining.
+func relayOutbox() int {
+	claimed, err := db.Repo.ClaimOutbox(outboxBatch, outboxLease)
+	if err != nil {
+		log.Printf("[OUTBOX] Claim failed: %v", err)
+		return 0
+	}
+
+	// Decode the batch and send it in one go, so the producer can batch it
+	evs := make([]events.Event, 0, len(claimed))
+	errs := make([]error, len(claimed))
+	decoded := make([]int, 0, len(claimed))
+	for i, e := range claimed {
+		ev, err := events.Decode(e.Payload)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		evs = append(evs, ev)
+		decoded = append(decoded, i)
+	}
+	for j, err := range analytics.SendAll(analytics.Producer, evs) {
+		errs[decoded[j]] = err
+	}
+
+	var sent []int64
+	for i, e := range claimed {
+		if err := errs[i]; err != nil {
+			retry := time.Now().Add(outboxBackoff(e.Attempts))
+			log.Printf("[OUTBOX] Event %d (%s) failed, attempt %d, retrying at %s: %v",
+				e.ID, e.Type, e.Attempts+1, retry.Format(time.TimeOnly), err)
+			if err := db.Repo.MarkOutboxFailed(e.ID, err, retry); err != nil {
+				log.Printf("[OUTBOX] Failed to record failure of event %d: %v", e.ID, err)
+			}
+			continue
+		}
+		sent = append(sent, e.ID)
+	}
+
+	if err := db.Repo.MarkOutboxSent(sent...); err != nil {
+		log.Printf("[OUTBOX] Failed to mark %d events sent, they will be resent: %v", len(sent), err)
+	}
+	if len(sent) < len(claimed) {
+		return 0
+	}
+	return len(claimed)
+}
+
+// outboxBackoff doubles from one second up to outboxMaxDelay.
+func outboxBackoff(attempts int) time.Duration {
+	d := time.Second << min(attempts, 16)
+	return min(d, outboxMaxDelay)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"fourinrow/db"
+	"fourinrow/game"
+	"fourinrow/game/bot"
+)
+
+// SnapshotInterval controls how live games are persisted. Zero saves a
+// snapshot after every move; otherwise changed games are saved on a timer.
+var SnapshotInterval time.Duration
+
+// ReconnectGrace is how long players get to come back after a restart before
+// their restored game is decided without them.
+var ReconnectGrace = 60 * time.Second
+
+const adjudicationTime = 2 * time.Second
+
+// finishMu stops two expiring timers from finishing the same game twice.
+var finishMu sync.Mutex
+
+// pendingSnapshots holds the latest copy of each game that has moved since
+// the snapshotter last ran. The copies are taken by the goroutine that made
+// the move, so the snapshotter never reads a game while it changes.
+var pendingSnapshots = struct {
+	sync.Mutex
+	games map[string]*game.Snapshot
+}{games: make(map[string]*game.Snapshot)}
+
+// flushMu is held while the snapshotter writes, so a finished game's
+// snapshot can't be saved again after it was deleted.
+var flushMu sync.Mutex
+
+func saveSnapshot(snap *game.Snapshot) {
+	if err := db.Repo.SaveLiveGame(snap); err != nil {
+		log.Printf("[DB ERROR] Failed to snapshot game %s: %v", snap.ID, err)
+	}
+}
+
+// snapshotMove persists a game after a move, or queues a copy of it for the
+// snapshotter. It runs on the goroutine that changed the game.
+func snapshotMove(g *game.Game) {
+	snap := game.TakeSnapshot(g)
+	if SnapshotInterval == 0 {
+		saveSnapshot(snap)
+		return
+	}
+	pendingSnapshots.Lock()
+	pendingSnapshots.games[g.ID] = snap
+	pendingSnapshots.Unlock()
+}
+
+// forgetSnapshot drops a finished game's queued snapshot. Call it before
+// deleting the saved one.
+func forgetSnapshot(gameID string) {
+	flushMu.Lock()
+	defer flushMu.Unlock()
+	pendingSnapshots.Lock()
+	delete(pendingSnapshots.games, gameID)
+	pendingSnapshots.Unlock()
+}
+
+// StartSnapshotter saves the games that have moved since the last run,
+// every SnapshotInterval. It does nothing in per-move mode.
+func StartSnapshotter() {
+	if SnapshotInterval == 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(SnapshotInterval) {
+			flushMu.Lock()
+			pendingSnapshots.Lock()
+			snaps := pendingSnapshots.games
+			pendingSnapshots.games = make(map[string]*game.Snapshot)
+			pendingSnapshots.Unlock()
+			for _, snap := range snaps {
+				saveSnapshot(snap)
+			}
+			flushMu.Unlock()
+		}
+	}()
+}
+
+// RestoreLiveGames reloads the games that were in progress when the server
+// last stopped. Humans have ReconnectGrace to rejoin; see expireRestored.
+func RestoreLiveGames() {
+	snaps, err := db.Repo.ListLiveGames()
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to load live games: %v", err)
+		return
+	}
+
+	restored := 0
+	for _, snap := range snaps {
+		// With several nodes on one database, each restores its own games
+		ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+		owner, err := Cluster.Owner(ctx, snap.ID)
+		cancel()
+		if err != nil || (owner != "" && owner != NodeID) {
+			continue
+		}
+
+		g := snap.Restore()
+		game.Store.AddGame(g)
+		registerGame(g)
+		restored++
+		for _, p := range g.Players {
+			if p.IsBot {
+				continue
+			}
+			p.DisconnectTimer = time.AfterFunc(ReconnectGrace, func() { expireRestored(g, p) })
+		}
+
+		// The server may have stopped while the bot was thinking
+		if g.CurrentTurn == "cpu" {
+			go playBotTurn(g)
+		}
+	}
+	if restored > 0 {
+		log.Printf("[RESTORE] Restored %d live games, players have %s to reconnect", restored, ReconnectGrace)
+	}
+}
+
+// expireRestored runs when a player of a restored game has not come back in
+// time. If their opponent is here, the opponent wins as for any disconnect.
+// If nobody came back, a bot opponent wins and a human game is adjudicated.
+func expireRestored(g *game.Game, gone *game.Player) {
+	finishMu.Lock()
+	defer finishMu.Unlock()
+	if g.Status != "playing" || gone.IsConnected {
+		return
+	}
+
+	var opponent *game.Player
+	for _, p := range g.Players {
+		if p != gone {
+			opponent = p
+		}
+	}
+
+	g.Status = "finished"
+	g.FinishReason = game.FinishDisconnect
+	switch {
+	case opponent == nil:
+		g.Winner = "draw"
+	case opponent.IsConnected:
+		g.Winner = opponent.ID
+	default:
+		adjudicate(g)
+	}
+
+	log.Printf("[RESTORE] Game %s finished without %s (%s)", g.ID, gone.Username, g.FinishReason)
+	BroadcastState(g)
+	HandleGameOver(g)
+}
+
+// adjudicate decides an abandoned position with the engine: a forced win for
+// either side stands, anything else is a draw.
+func adjudicate(g *game.Game) {
+	g.FinishReason = game.FinishAdjudicated
+	g.Winner = "draw"
+
+	var toMove *game.Player
+	for _, p := range g.Players {
+		if p.ID == g.CurrentTurn {
+			toMove = p
+		}
+	}
+	if toMove == nil {
+		return
+	}
+
+	res := bot.Analyze(g.Board, toMove.Color, adjudicationTime)
+	switch bot.Outcome(res.Score) {
+	case 1:
+		g.Winner = toMove.ID
+	case -1:
+		for _, p := range g.Players {
+			if p != toMove {
+				g.Winner = p.ID
+			}
+		}
+	}
+}
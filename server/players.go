@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"fourinrow/db"
+)
+
+const defaultRecentGames = 10
+
+// PlayerHandler serves GET /players/{name}
+func PlayerHandler(w http.ResponseWriter, r *http.Request) {
+	recent := defaultRecentGames
+	if n, err := strconv.Atoi(r.URL.Query().Get("recent")); err == nil && n >= 0 && n <= 100 {
+		recent = n
+	}
+
+	profile, err := db.Repo.GetPlayerProfile(r.PathValue("name"), recent)
+	if err != nil {
+		http.Error(w, "Failed to load player", http.StatusInternalServerError)
+		return
+	}
+	if profile == nil {
+		http.Error(w, "Player not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// HeadToHeadHandler serves GET /players/{a}/vs/{b}
+func HeadToHeadHandler(w http.ResponseWriter, r *http.Request) {
+	a, b := r.PathValue("a"), r.PathValue("b")
+	if a == b {
+		http.Error(w, "Pick two different players", http.StatusBadRequest)
+		return
+	}
+	recent := defaultRecentGames
+	if n, err := strconv.Atoi(r.URL.Query().Get("recent")); err == nil && n >= 0 && n <= 100 {
+		recent = n
+	}
+
+	h2h, err := db.Repo.GetHeadToHead(a, b, recent)
+	if err != nil {
+		http.Error(w, "Failed to load head-to-head record", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h2h)
+}
+
+// previousMeetings returns the record between two players for the "start"
+// message, or nil if they have never met. It queries the database, so
+// callers must not hold the matchmaker lock.
+func previousMeetings(a, b string) *db.HeadToHead {
+	h2h, err := db.Repo.GetHeadToHeadTotals(a, b)
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to load head-to-head for %s vs %s: %v", a, b, err)
+		return nil
+	}
+	if h2h.Games == 0 {
+		return nil
+	}
+	return h2h
+}
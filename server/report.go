@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"fourinrow/db"
+	"fourinrow/game"
+	"fourinrow/game/bot"
+)
+
+// At most this many reports are analysed at once, so a burst of finished
+// games cannot starve live bot moves of CPU.
+const maxReportJobs = 2
+
+// Recent reports are also cached here so polling clients don't hit the store.
+const maxCachedReports = 500
+
+var reports = struct {
+	mu      sync.Mutex
+	pending map[string]bool
+	done    map[string]*game.Report
+	order   []string
+	slots   chan struct{}
+}{
+	pending: make(map[string]bool),
+	done:    make(map[string]*game.Report),
+	slots:   make(chan struct{}, maxReportJobs),
+}
+
+// queueReport starts the blunder analysis for a finished game in the background.
+func queueReport(g *game.Game) {
+	if len(g.Moves) == 0 {
+		return
+	}
+
+	// Copy what the job needs; the game keeps being touched by other goroutines
+	moves := append([]game.Move(nil), g.Moves...)
+	players := make([]game.PlayerAccuracy, 0, len(g.Players))
+	for _, p := range g.Players {
+		players = append(players, game.PlayerAccuracy{Username: p.Username, Color: p.Color})
+	}
+	gameID := g.ID
+
+	reports.mu.Lock()
+	reports.pending[gameID] = true
+	reports.mu.Unlock()
+
+	go func() {
+		reports.slots <- struct{}{}
+		defer func() { <-reports.slots }()
+
+		start := time.Now()
+		rep := buildReport(gameID, moves, players)
+		log.Printf("[REPORT] Game %s analysed in %s", gameID, time.Since(start).Round(time.Millisecond))
+
+		if err := db.Repo.SaveReport(rep); err != nil {
+			log.Printf("[DB ERROR] Failed to save report %s: %v", gameID, err)
+		}
+
+		reports.mu.Lock()
+		delete(reports.pending, gameID)
+		reports.done[gameID] = rep
+		reports.order = append(reports.order, gameID)
+		if len(reports.order) > maxCachedReports {
+			delete(reports.done, reports.order[0])
+			reports.order = reports.order[1:]
+		}
+		reports.mu.Unlock()
+	}()
+}
+
+func buildReport(gameID string, moves []game.Move, players []game.PlayerAccuracy) *game.Report {
+	reviews := bot.Review(moves)
+
+	for i := range players {
+		p := &players[i]
+		p.Accuracy = bot.Accuracy(reviews, p.Color)
+		p.Counts = make(map[string]int)
+		for _, r := range reviews {
+			if r.Color == p.Color {
+				p.Counts[r.Quality]++
+			}
+		}
+	}
+
+	return &game.Report{
+		GameID:    gameID,
+		Players:   players,
+		Moves:     reviews,
+		CreatedAt: time.Now(),
+	}
+}
+
+// ReportHandler serves GET /games/{id}/report
+func ReportHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	reports.mu.Lock()
+	rep, pending := reports.done[id], reports.pending[id]
+	reports.mu.Unlock()
+
+	if rep == nil && !pending {
+		stored, err := db.Repo.GetReport(id)
+		if err != nil {
+			http.Error(w, "Failed to load report", http.StatusInternalServerError)
+			return
+		}
+		rep = stored
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case rep != nil:
+		json.NewEncoder(w).Encode(rep)
+	case pending:
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"status": "pending"})
+	default:
+		http.Error(w, "Report not found", http.StatusNotFound)
+	}
+}
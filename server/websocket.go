@@ -2,12 +2,15 @@ package server
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"time"
 
 	"fourinrow/analytics" // <--- Added this import
 	"fourinrow/db"
+	"fourinrow/events"
 	"fourinrow/game"
+	"fourinrow/game/bot"
 
 	"github.com/gorilla/websocket"
 )
@@ -23,12 +26,19 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	connectedSockets.Inc()
+	defer connectedSockets.Dec()
 
 	username := r.URL.Query().Get("username")
 	if username == "" {
 		conn.Close()
 		return
 	}
+	if bot.IsReservedUsername(username) {
+		conn.WriteJSON(game.WSMessage{Type: "error", Payload: "Usernames starting with " + bot.UsernamePrefix + " are reserved"})
+		conn.Close()
+		return
+	}
 
 	// JOIN THE MATCHMAKER
 	GlobalMatchmaker.Join(username, conn)
@@ -37,7 +47,8 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
-			handleDisconnect(username)
+			GlobalMatchmaker.Leave(username, conn)
+			disconnect(username, conn)
 			break
 		}
 
@@ -45,19 +56,54 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		if err := json.Unmarshal(message, &msg); err != nil {
 			continue
 		}
+		handleMessage(username, message, msg)
+	}
+}
 
-		if msg.Type == "move" {
-			g := game.Store.FindGameByPlayerName(username)
-			if g != nil {
-				payload := msg.Payload.(map[string]interface{})
-				col := int(payload["column"].(float64))
-				// Call the MATCHMAKER'S HandleMove
-				HandleMove(g, username, col)
-			}
+// handleMessage runs a client message against the player's game, or forwards
+// it to the node that owns the game.
+func handleMessage(username string, raw []byte, msg game.WSMessage) {
+	if g := game.Store.FindGameByPlayerName(username); g != nil {
+		dispatch(g, username, msg)
+		return
+	}
+	if s := remoteSessionFor(username); s != nil {
+		publish(s.route.Owner, Envelope{Type: envInput, Username: username, GameID: s.route.GameID, Message: raw})
+	}
+}
+
+func dispatch(g *game.Game, username string, msg game.WSMessage) {
+	if msg.Type == "move" {
+		payload, ok := msg.Payload.(map[string]interface{})
+		if !ok {
+			return
 		}
+		col, ok := payload["column"].(float64)
+		if !ok {
+			return
+		}
+		// Call the MATCHMAKER'S HandleMove
+		HandleMove(g, username, int(col))
+	} else if msg.Type == "hint" {
+		handleHint(g, username)
+	}
+}
+
+// disconnect handles a closed socket, telling the owning node if the game
+// lives elsewhere.
+func disconnect(username string, conn *websocket.Conn) {
+	if s := remoteSessionFor(username); s != nil && s.conn == conn {
+		setRemoteSession(username, nil)
+		publish(s.route.Owner, Envelope{Type: envDetach, Username: username, GameID: s.route.GameID})
+		return
 	}
+	handleDisconnect(username)
 }
 
+// DisconnectGrace is how long a player who drops mid-game has to come back
+// before their opponent wins.
+const DisconnectGrace = 30 * time.Second
+
 func handleDisconnect(username string) {
 	g := game.Store.FindGameByPlayerName(username)
 	if g == nil || g.Status == "finished" {
@@ -66,13 +112,19 @@ func handleDisconnect(username string) {
 
 	player := g.Players[username]
 	player.IsConnected = false
+	player.DisconnectedAt = time.Now()
+	disconnects.Inc()
+	emit(playerEvent(events.PlayerDisconnected, g, player, events.PlayerDisconnectedPayload{
+		Ply: len(g.Moves), GraceMs: DisconnectGrace.Milliseconds(),
+	}))
 	
 	// FIX 1: Broadcast immediately so the other player knows about the disconnection
 	BroadcastState(g)
 
-	player.DisconnectTimer = time.AfterFunc(30*time.Second, func() {
+	player.DisconnectTimer = time.AfterFunc(DisconnectGrace, func() {
 		if !player.IsConnected {
 			g.Status = "finished"
+			g.FinishReason = game.FinishDisconnect
 
 			// FIX 2: Set the Real Winner ID instead of generic "opponent"
 			// Find the player who is NOT the one that disconnected
@@ -98,18 +150,37 @@ func BroadcastState(g *game.Game) {
 }
 
 func HandleGameOver(g *game.Game) {
-	// 1. Save to Database
-	if db.Repo != nil {
-		db.Repo.SaveGame(g)
+	// 0. Drop the bot's per-game search table (no-op when tables are shared)
+	// and unlist the game so its players can be matched again
+	bot.ReleaseGame(g.ID)
+	game.Store.FinishGame(g)
+	unregisterGame(g)
+	gamesFinished.With(gameMode(g), gameResult(g)).Inc()
+
+	// 1. Save to Database together with the "game over" analytics events, and
+	// drop the live snapshot. The outbox relay publishes the events.
+	finished := finishEvents(g)
+	var queued []db.OutboxEvent
+	for _, e := range finished {
+		if q, err := outboxEvent(e); err != nil {
+			log.Printf("[OUTBOX] Dropping %s event: %v", e.Type, err)
+		} else {
+			queued = append(queued, q)
+		}
+	}
+	if err := db.Repo.SaveGame(g, queued...); err != nil {
+		log.Printf("[DB ERROR] Failed to save game %s: %v", g.ID, err)
+		for _, e := range finished {
+			analytics.Producer.Emit(e)
+		}
+	} else {
+		wakeOutbox()
+	}
+	forgetSnapshot(g.ID)
+	if err := db.Repo.DeleteLiveGame(g.ID); err != nil {
+		log.Printf("[DB ERROR] Failed to delete snapshot of game %s: %v", g.ID, err)
 	}
 
-	// 2. Send "Game Over" Event to Kafka (Analytics)
-	// We send the Winner's name/ID so the consumer can count wins & duration
-	analytics.Producer.Emit(analytics.GameEvent{
-		Type:      "game_finished",
-		GameID:    g.ID,
-		PlayerID:  g.Winner, 
-		Timestamp: time.Now().Unix(),
-		Payload:   g.Winner, // Payload = Winner's Name
-	})
+	// 2. Queue the post-game blunder report
+	queueReport(g)
 }
\ No newline at end of file